@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+
+	"al.essio.dev/pkg/shellescape"
+)
+
+var aliasNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// aliasCommand returns the git config command that defines "git <name>" as
+// lazycommit run with args. A shell alias is used so any arguments given to
+// the alias are passed through.
+func aliasCommand(name string, global bool, args []string) (*exec.Cmd, error) {
+	if !aliasNameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid alias name %q: must be alphanumeric with dashes", name)
+	}
+	value := "!lazycommit"
+	if len(args) > 0 {
+		value += " " + shellescape.QuoteCommand(args)
+	}
+	cmd := exec.Command(gitBinary, "config")
+	if global {
+		cmd.Args = append(cmd.Args, "--global")
+	}
+	cmd.Args = append(cmd.Args, "alias."+name, value)
+	return cmd, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// changeGroup is a set of unstaged paths committed together by the batch
+// command.
+type changeGroup struct {
+	name  string
+	paths []string
+}
+
+// unstagedPaths returns the tracked files with unstaged changes and the
+// untracked files not ignored by git, relative to root, the top of the
+// working tree.
+func unstagedPaths(root string) ([]string, error) {
+	var paths []string
+	for _, args := range [][]string{
+		{"diff", "--name-only", "-z"},
+		{"ls-files", "--others", "--exclude-standard", "-z"},
+	} {
+		cmd, cancel := gitCommand(append([]string{"-C", root}, args...)...)
+		output, err := cmd.Output()
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+		}
+		for _, p := range strings.Split(string(output), "\x00") {
+			if p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// groupByDir groups paths by their first depth directories. Files above
+// that depth are grouped by their own directory, so files at the
+// repository root form the group ".".
+func groupByDir(paths []string, depth int) []changeGroup {
+	byDir := map[string][]string{}
+	for _, p := range paths {
+		dirs := strings.Split(path.Dir(p), "/")
+		if len(dirs) > depth {
+			dirs = dirs[:depth]
+		}
+		dir := strings.Join(dirs, "/")
+		byDir[dir] = append(byDir[dir], p)
+	}
+
+	groups := make([]changeGroup, 0, len(byDir))
+	for dir, paths := range byDir {
+		sort.Strings(paths)
+		groups = append(groups, changeGroup{name: dir, paths: paths})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+// changeGroups groups the unstaged changes for the batch command. dir is
+// the only supported grouping.
+func changeGroups(root string, groupBy string, depth int) ([]changeGroup, error) {
+	if groupBy != "dir" {
+		return nil, fmt.Errorf("invalid --group-by %q: must be dir", groupBy)
+	}
+	if depth < 1 {
+		return nil, fmt.Errorf("--group-depth must be at least 1, got %d", depth)
+	}
+	paths, err := unstagedPaths(root)
+	if err != nil {
+		return nil, err
+	}
+	return groupByDir(paths, depth), nil
+}
+
+// stagePaths stages paths relative to root, including deletions and
+// untracked files.
+func stagePaths(root string, paths []string) error {
+	_, err := gitOutput(append([]string{"-C", root, "add", "--all", "--"}, paths...)...)
+	return err
+}
+
+// unstagePaths undoes stagePaths, leaving the working tree alone.
+func unstagePaths(root string, paths []string) error {
+	_, err := gitOutput(append([]string{"-C", root, "reset", "--quiet", "--"}, paths...)...)
+	return err
+}
+
+// runBatch commits each group of unstaged changes separately, generating a
+// message for each. With --dry-run each group is staged only while its
+// message is generated.
+func runBatch(opts runOptions, groupBy string, depth int) error {
+	if opts.amend || opts.ref != "" || opts.allowEmpty || (opts.diffSource != "" && opts.diffSource != "staged") {
+		return errors.New("batch cannot be used with [ref], --amend, --allow-empty or --diff-source")
+	}
+	staged, err := hasStagedChanges()
+	if err != nil {
+		return err
+	}
+	if staged {
+		return errors.New("batch needs an empty staging area, commit or unstage the staged changes first")
+	}
+	// git diff lists paths from the top of the working tree, so every
+	// command runs there.
+	root, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	groups, err := changeGroups(root, groupBy, depth)
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return fmt.Errorf("%w: no unstaged changes to commit", errNoChanges)
+	}
+
+	for i, g := range groups {
+		fmt.Fprintf(os.Stderr, "==> [%d/%d] %s (%d files)\n", i+1, len(groups), g.name, len(g.paths))
+		if err := stagePaths(root, g.paths); err != nil {
+			return fmt.Errorf("staging %s: %w", g.name, err)
+		}
+		err := run(opts)
+		if opts.dryRun {
+			if err := unstagePaths(root, g.paths); err != nil {
+				return fmt.Errorf("unstaging %s: %w", g.name, err)
+			}
+		}
+		if err != nil {
+			// Leave the group staged so it can be committed by hand.
+			return fmt.Errorf("committing %s: %w", g.name, err)
+		}
+	}
+	return nil
+}
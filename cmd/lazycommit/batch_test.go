@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupByDir(t *testing.T) {
+	paths := []string{"README.md", "cmd/lazycommit/main.go", "cmd/tool/x.go", "docs/a.md", "cmd/lazycommit/diff.go", "go.mod"}
+	tests := []struct {
+		depth int
+		want  []changeGroup
+	}{
+		{
+			depth: 1,
+			want: []changeGroup{
+				{name: ".", paths: []string{"README.md", "go.mod"}},
+				{name: "cmd", paths: []string{"cmd/lazycommit/diff.go", "cmd/lazycommit/main.go", "cmd/tool/x.go"}},
+				{name: "docs", paths: []string{"docs/a.md"}},
+			},
+		},
+		{
+			depth: 2,
+			want: []changeGroup{
+				{name: ".", paths: []string{"README.md", "go.mod"}},
+				{name: "cmd/lazycommit", paths: []string{"cmd/lazycommit/diff.go", "cmd/lazycommit/main.go"}},
+				{name: "cmd/tool", paths: []string{"cmd/tool/x.go"}},
+				{name: "docs", paths: []string{"docs/a.md"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := groupByDir(paths, tt.depth); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("groupByDir(depth %d) = %+v, want %+v", tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestUnstagedPaths(t *testing.T) {
+	root := initTestRepo(t)
+	writeTestFile(t, "a/tracked.txt", "one\n")
+	writeTestFile(t, "b/deleted.txt", "one\n")
+	writeTestFile(t, ".gitignore", "*.log\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+
+	writeTestFile(t, "a/tracked.txt", "two\n")
+	if err := os.Remove("b/deleted.txt"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, "c/new.txt", "new\n")
+	writeTestFile(t, "c/ignored.log", "log\n")
+
+	// Paths are relative to root wherever lazycommit runs.
+	if err := os.Chdir("a"); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := changeGroups(root, "dir", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []changeGroup{
+		{name: "a", paths: []string{"a/tracked.txt"}},
+		{name: "b", paths: []string{"b/deleted.txt"}},
+		{name: "c", paths: []string{"c/new.txt"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Fatalf("changeGroups() = %+v, want %+v", groups, want)
+	}
+
+	if err := stagePaths(root, []string{"b/deleted.txt", "c/new.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testGit(t, "diff", "--cached", "--name-status"); got != "D\tb/deleted.txt\nA\tc/new.txt" {
+		t.Errorf("staged %q", got)
+	}
+	if err := unstagePaths(root, []string{"b/deleted.txt", "c/new.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testGit(t, "diff", "--cached", "--name-only"); got != "" {
+		t.Errorf("still staged after unstagePaths: %q", got)
+	}
+
+	if _, err := changeGroups(root, "file", 1); err == nil {
+		t.Error("changeGroups() accepted --group-by file")
+	}
+	if _, err := changeGroups(root, "dir", 0); err == nil {
+		t.Error("changeGroups() accepted depth 0")
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "README.md", "hello\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+
+	writeTestFile(t, "api/server.go", "package api\n")
+	writeTestFile(t, "docs/guide.md", "# Guide\n")
+	writeTestFile(t, "README.md", "hello, world\n")
+
+	client, api := newTestClient(t, "Update the readme", "Add the API server", "Add a guide")
+	if err := os.Chdir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runBatch(testRunOptions(client), "dir", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := api.requestCount(); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+	log := testGit(t, "log", "--format=%s", "--name-only", "-3")
+	want := "Add a guide\n\ndocs/guide.md\nAdd the API server\n\napi/server.go\nUpdate the readme\n\nREADME.md"
+	if log != want {
+		t.Errorf("git log =\n%s\nwant\n%s", log, want)
+	}
+	if status := testGit(t, "status", "--porcelain"); status != "" {
+		t.Errorf("changes left after batch:\n%s", status)
+	}
+
+	if err := runBatch(testRunOptions(client), "dir", 1); err == nil || !strings.Contains(err.Error(), "no unstaged changes") {
+		t.Errorf("runBatch() with nothing to commit = %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	// Registered for image.DecodeConfig.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+var (
+	indexLineRe = regexp.MustCompile(`(?m)^index ([0-9a-f]+)\.\.([0-9a-f]+)`)
+	// nullObjectRe matches the all-zero id git uses for a missing side.
+	nullObjectRe = regexp.MustCompile(`^0+$`)
+)
+
+// isBinaryDiff reports whether git printed f as a binary file.
+func isBinaryDiff(f fileDiff) bool {
+	for _, line := range strings.Split(f.text, "\n") {
+		if strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ") {
+			return true
+		}
+	}
+	return false
+}
+
+// blobInfo describes one side of a binary change.
+type blobInfo struct {
+	size int
+	// width and height are zero unless the blob is a known image format.
+	width, height int
+}
+
+func readBlobInfo(dir string, id string) (blobInfo, error) {
+	cmd, cancel := gitCommand("-C", dir, "cat-file", "blob", id)
+	defer cancel()
+	data, err := cmd.Output()
+	if err != nil {
+		return blobInfo{}, fmt.Errorf("git cat-file blob %s: %w", id, err)
+	}
+	return newBlobInfo(data), nil
+}
+
+// readFileInfo describes the file at path in the working tree, for
+// working-tree diffs whose new blobs aren't in the object database.
+func readFileInfo(path string) (blobInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return blobInfo{}, err
+	}
+	return newBlobInfo(data), nil
+}
+
+func newBlobInfo(data []byte) blobInfo {
+	info := blobInfo{size: len(data)}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		info.width, info.height = cfg.Width, cfg.Height
+	}
+	return info
+}
+
+func (b blobInfo) String() string {
+	s := formatSize(b.size)
+	if b.width > 0 && b.height > 0 {
+		s += fmt.Sprintf(" (%dx%d)", b.width, b.height)
+	}
+	return s
+}
+
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// describeBinary summarizes a binary file change from the blobs named in
+// its index line, e.g. "icon.png (png): added, 1.2 KiB (24x24)". gitRoot is
+// the top of the working tree.
+func describeBinary(gitRoot string, f fileDiff) (string, error) {
+	m := indexLineRe.FindStringSubmatch(f.text)
+	if m == nil {
+		return "", fmt.Errorf("no index line for %s", f.path)
+	}
+	desc := f.path
+	if ext := strings.TrimPrefix(filepath.Ext(f.path), "."); ext != "" {
+		desc += " (" + strings.ToLower(ext) + ")"
+	}
+
+	var sides []string
+	for i, id := range m[1:] {
+		if nullObjectRe.MatchString(id) {
+			sides = append(sides, "")
+			continue
+		}
+		info, err := readBlobInfo(gitRoot, id)
+		if err != nil && i == 1 {
+			// The new side of a working-tree diff is only on disk.
+			info, err = readFileInfo(filepath.Join(gitRoot, f.path))
+		}
+		if err != nil {
+			return "", err
+		}
+		sides = append(sides, info.String())
+	}
+	switch {
+	case sides[0] == "":
+		return fmt.Sprintf("%s: added, %s", desc, sides[1]), nil
+	case sides[1] == "":
+		return fmt.Sprintf("%s: deleted, was %s", desc, sides[0]), nil
+	}
+	return fmt.Sprintf("%s: changed from %s to %s", desc, sides[0], sides[1]), nil
+}
+
+// binarySummary describes the binary files in diff, or returns "" if there
+// are none.
+func binarySummary(gitRoot string, diff string) (string, error) {
+	var lines []string
+	for _, f := range splitDiff(diff) {
+		if !isBinaryDiff(f) {
+			continue
+		}
+		desc, err := describeBinary(gitRoot, f)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, "- "+desc)
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return "Binary files changed:\n" + strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeTestPNG writes a blank width x height PNG to path.
+func writeTestPNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSummarizeBinaryWorkingTree(t *testing.T) {
+	initTestRepo(t)
+	writeTestPNG(t, "icon.png", 16, 16)
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+	// The new image is only in the working tree, not the object database.
+	writeTestPNG(t, "icon.png", 32, 24)
+
+	client, api := newTestClient(t, "Enlarge the icon")
+	opts := testRunOptions(client)
+	opts.diffSource = "working"
+	opts.summarizeBinary = true
+	opts.dryRun = true
+	capture(t, &os.Stdout, func() {
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if prompt := api.prompt(0); !strings.Contains(prompt, "(16x16) to ") || !strings.Contains(prompt, "(32x24)") {
+		t.Errorf("the prompt doesn't describe both sizes of icon.png:\n%s", prompt)
+	}
+}
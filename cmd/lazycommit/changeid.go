@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var changeIDRe = regexp.MustCompile(`(?m)^Change-Id: (I[0-9a-f]{40})\s*$`)
+
+// existingChangeID returns the Change-Id of commit, or "" if it has none.
+// Amended and reworded commits keep their Change-Id so Gerrit treats them
+// as a new patch set of the same change.
+func existingChangeID(commit string) (string, error) {
+	msg, err := gitOutput("log", "-1", "--format=%B", commit)
+	if err != nil {
+		return "", err
+	}
+	_, trailers := splitTrailers(msg)
+	for _, t := range trailers {
+		if m := changeIDRe.FindStringSubmatch(t); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", nil
+}
+
+// newChangeID computes a Change-Id the way Gerrit's classic commit-msg
+// hook does: the id of the tree, parent, author, committer and message
+// hashed as a commit object, as git hash-object -t commit prints it,
+// prefixed with I.
+func newChangeID(msg string) (string, error) {
+	tree, err := gitOutput("write-tree")
+	if err != nil {
+		return "", err
+	}
+	author, err := gitOutput("var", "GIT_AUTHOR_IDENT")
+	if err != nil {
+		return "", err
+	}
+	committer, err := gitOutput("var", "GIT_COMMITTER_IDENT")
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "tree %s\n", tree)
+	// The first commit has no parent.
+	if parent, err := gitOutput("rev-parse", "--verify", "--quiet", "HEAD^0"); err == nil {
+		fmt.Fprintf(&b, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&b, "author %s\ncommitter %s\n\n%s", author, committer, msg)
+	obj := fmt.Sprintf("commit %d\x00%s", b.Len(), b.String())
+	return fmt.Sprintf("I%x", sha1.Sum([]byte(obj))), nil
+}
+
+// changeIDTrailer returns the Change-Id for msg. If replacing is set, it
+// names the commit msg replaces, whose Change-Id is reused.
+func changeIDTrailer(msg string, replacing string) (string, error) {
+	if replacing != "" {
+		id, err := existingChangeID(replacing)
+		if err != nil {
+			return "", err
+		}
+		if id != "" {
+			return id, nil
+		}
+	}
+	return newChangeID(msg)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNewChangeID(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	// The first commit has no parent.
+	for _, withParent := range []bool{false, true} {
+		if withParent {
+			testGit(t, "commit", "--quiet", "-m", "initial")
+			writeTestFile(t, "a.txt", "two\n")
+			testGit(t, "add", ".")
+		}
+		const msg = "fix: typo\n"
+		id, err := newChangeID(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Gerrit's hook pipes the same text to git hash-object.
+		input := fmt.Sprintf("tree %s\n", testGit(t, "write-tree"))
+		if withParent {
+			input += fmt.Sprintf("parent %s\n", testGit(t, "rev-parse", "HEAD"))
+		}
+		input += fmt.Sprintf("author %s\ncommitter %s\n\n%s",
+			testGit(t, "var", "GIT_AUTHOR_IDENT"), testGit(t, "var", "GIT_COMMITTER_IDENT"), msg)
+		cmd := exec.Command("git", "hash-object", "-t", "commit", "--stdin")
+		cmd.Stdin = strings.NewReader(input)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "I" + strings.TrimSpace(string(output)); id != want {
+			t.Errorf("withParent=%v: newChangeID() = %s, want %s", withParent, id, want)
+		}
+		if !changeIDRe.MatchString("Change-Id: " + id) {
+			t.Errorf("newChangeID() = %q, which is not a valid Change-Id", id)
+		}
+	}
+}
+
+func TestChangeIDTrailer(t *testing.T) {
+	initTestRepo(t)
+	const id = "I0123456789abcdef0123456789abcdef01234567"
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "fix: typo\n\nBody.\n\nChange-Id: "+id+"\nSigned-off-by: Test User <test@example.com>")
+	writeTestFile(t, "a.txt", "two\n")
+	testGit(t, "commit", "--quiet", "-am", "fix: another typo\n\nChange-Id: in the body, not a trailer")
+
+	got, err := existingChangeID("HEAD~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("existingChangeID(HEAD~1) = %q, want %q", got, id)
+	}
+	if got, err := existingChangeID("HEAD"); err != nil || got != "" {
+		t.Errorf("existingChangeID(HEAD) = %q, %v, want none", got, err)
+	}
+
+	// Replacing a commit keeps its Change-Id.
+	if got, err := changeIDTrailer("fix: reworded", "HEAD~1"); err != nil || got != id {
+		t.Errorf("changeIDTrailer(replacing HEAD~1) = %q, %v, want %q", got, err, id)
+	}
+	// A new one is made when there is nothing to keep.
+	fresh, err := newChangeID("fix: reworded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, replacing := range []string{"", "HEAD"} {
+		if got, err := changeIDTrailer("fix: reworded", replacing); err != nil || got != fresh {
+			t.Errorf("changeIDTrailer(replacing %q) = %q, %v, want %q", replacing, got, err, fresh)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	unreleasedHeading = "## Unreleased"
+	changelogPrompt   = "Instead of a commit message, write a single past-tense " +
+		"sentence for a CHANGELOG entry. Describe the change from the user's " +
+		"point of view and leave out implementation details."
+)
+
+// insertChangelogEntry adds entry as a bullet at the top of the Unreleased
+// section of changelog, creating the section if it doesn't exist.
+func insertChangelogEntry(changelog string, entry string) string {
+	bullet := "- " + strings.TrimSpace(entry)
+	if strings.TrimSpace(changelog) == "" {
+		return unreleasedHeading + "\n\n" + bullet + "\n"
+	}
+	lines := strings.Split(changelog, "\n")
+
+	for i, line := range lines {
+		if strings.EqualFold(strings.TrimSpace(line), unreleasedHeading) {
+			// Keep a blank line between the heading and its entries.
+			insertAt := i + 1
+			if insertAt < len(lines) && strings.TrimSpace(lines[insertAt]) == "" {
+				insertAt++
+			}
+			return strings.Join(insertLines(lines, insertAt, bullet), "\n")
+		}
+	}
+
+	section := []string{unreleasedHeading, "", bullet, ""}
+	// Place the new section below the document title, if there is one.
+	insertAt := 0
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "# ") {
+		insertAt = 1
+		for insertAt < len(lines) && strings.TrimSpace(lines[insertAt]) == "" {
+			insertAt++
+		}
+		section = append([]string{""}, section...)
+		lines = append(lines[:1], lines[insertAt:]...)
+		insertAt = 1
+	}
+	return strings.Join(insertLines(lines, insertAt, section...), "\n")
+}
+
+func insertLines(lines []string, at int, add ...string) []string {
+	out := make([]string, 0, len(lines)+len(add))
+	out = append(out, lines[:at]...)
+	out = append(out, add...)
+	return append(out, lines[at:]...)
+}
+
+// changelogEntry reduces the model's reply to the single line of a
+// changelog bullet, dropping any preamble, code fence or extra lines.
+func changelogEntry(reply string) string {
+	msg := cleanMessage(reply)
+	// cleanMessage only recognizes commit message preambles.
+	if first, rest, ok := strings.Cut(msg, "\n"); ok && strings.HasSuffix(strings.TrimSpace(first), ":") {
+		msg = cleanMessage(rest)
+	}
+	for _, line := range strings.Split(msg, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// prependChangelogFile inserts entry into the changelog at path, creating the
+// file if needed.
+func prependChangelogFile(path string, entry string) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read changelog: %w", err)
+	}
+	updated := insertChangelogEntry(string(content), entry)
+	if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
+		return fmt.Errorf("write changelog: %w", err)
+	}
+	return nil
+}
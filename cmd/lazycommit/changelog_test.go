@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInsertChangelogEntry(t *testing.T) {
+	tests := []struct {
+		name      string
+		changelog string
+		want      string
+	}{
+		{
+			name:      "empty",
+			changelog: "",
+			want:      "## Unreleased\n\n- Added --batch.\n",
+		},
+		{
+			name:      "existing section",
+			changelog: "# Changelog\n\n## Unreleased\n\n- Fixed a crash.\n\n## 1.0.0\n\n- First release.\n",
+			want:      "# Changelog\n\n## Unreleased\n\n- Added --batch.\n- Fixed a crash.\n\n## 1.0.0\n\n- First release.\n",
+		},
+		{
+			name:      "heading in another case",
+			changelog: "## unreleased\n- Fixed a crash.\n",
+			want:      "## unreleased\n- Added --batch.\n- Fixed a crash.\n",
+		},
+		{
+			name:      "new section below the title",
+			changelog: "# Changelog\n\n\n## 1.0.0\n\n- First release.\n",
+			want:      "# Changelog\n\n## Unreleased\n\n- Added --batch.\n\n## 1.0.0\n\n- First release.\n",
+		},
+		{
+			name:      "new section without a title",
+			changelog: "## 1.0.0\n\n- First release.\n",
+			want:      "## Unreleased\n\n- Added --batch.\n\n## 1.0.0\n\n- First release.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := insertChangelogEntry(tt.changelog, " Added --batch.\n"); got != tt.want {
+				t.Errorf("insertChangelogEntry() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrependChangelogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "CHANGELOG.md")
+	for _, entry := range []string{"Added --batch.", "Fixed a crash."} {
+		if err := prependChangelogFile(path, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "## Unreleased\n\n- Fixed a crash.\n- Added --batch.\n"; string(b) != want {
+		t.Errorf("CHANGELOG.md = %q, want %q", b, want)
+	}
+}
+
+func TestChangelogEntry(t *testing.T) {
+	tests := map[string]string{
+		"Added --batch.": "Added --batch.",
+		"Added --batch.\n\nIt commits each directory separately.": "Added --batch.",
+		"Here is the changelog entry:\n```\nAdded --batch.\n```":  "Added --batch.",
+		"\n  Added --batch.  \n":                                  "Added --batch.",
+	}
+	for reply, want := range tests {
+		if got := changelogEntry(reply); got != want {
+			t.Errorf("changelogEntry(%q) = %q, want %q", reply, got, want)
+		}
+	}
+}
+
+func TestRunChangelogFile(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	client, _ := newTestClient(t, "Sure! Here is the entry:\n```\nAdded a.txt.\n\nIt holds one line.\n```")
+	opts := testRunOptions(client)
+	opts.changelog = true
+	opts.changelogFile = "CHANGELOG.md"
+	capture(t, &os.Stdout, func() {
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	b, err := os.ReadFile("CHANGELOG.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "## Unreleased\n\n- Added a.txt.\n"; string(b) != want {
+		t.Errorf("CHANGELOG.md = %q, want %q", b, want)
+	}
+}
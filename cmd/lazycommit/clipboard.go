@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// clipboardCommands are the tools that can print the clipboard, tried in
+// order.
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbpaste"}}
+	case "windows":
+		return [][]string{{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}}
+	}
+	return [][]string{
+		{"wl-paste", "--no-newline"},
+		{"xclip", "-selection", "clipboard", "-o"},
+		{"xsel", "--clipboard", "--output"},
+	}
+}
+
+// readClipboard returns the text on the system clipboard.
+func readClipboard() (string, error) {
+	for _, args := range clipboardCommands() {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, path, args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("reading clipboard with %s: %w", args[0], err)
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+	var names []string
+	for _, args := range clipboardCommands() {
+		names = append(names, args[0])
+	}
+	return "", errors.New("no clipboard tool found, install one of: " + strings.Join(names, ", "))
+}
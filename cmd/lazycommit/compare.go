@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/sashabaranov/go-openai"
+)
+
+// compareColumnGap separates the columns of the compare command.
+const compareColumnGap = "  "
+
+// minCompareColumnWidth is the narrowest column the compare command lays
+// out side by side. Narrower terminals get one model after another.
+const minCompareColumnWidth = 30
+
+// comparison is one model's result for the compare command.
+type comparison struct {
+	model string
+	stats string
+	text  string
+}
+
+// compareModels generates a message for req with each model and writes
+// them to w side by side. A failing model is reported in its column rather
+// than aborting the comparison.
+func compareModels(ctx context.Context, w io.Writer, client *openai.Client, req openai.ChatCompletionRequest, models []string) error {
+	results := make([]comparison, len(models))
+	for i, model := range models {
+		req.Model = model
+		gen, err := generate(ctx, io.Discard, client, req)
+		if err != nil && req.StreamOptions != nil && rejectsStreamOptions(err) {
+			req.StreamOptions = nil
+			gen, err = generate(ctx, io.Discard, client, req)
+		}
+		results[i] = comparison{model: model}
+		if err != nil {
+			results[i].text = "error: " + err.Error()
+			continue
+		}
+		results[i].text = strings.TrimSpace(cleanMessage(gen.text))
+		results[i].stats = gen.duration.Round(time.Millisecond).String()
+		if gen.usage != nil {
+			results[i].stats += fmt.Sprintf(", %d prompt + %d completion tokens",
+				gen.usage.PromptTokens, gen.usage.CompletionTokens)
+		}
+	}
+
+	width := (terminalWidth() - len(compareColumnGap)*(len(results)-1)) / len(results)
+	if width < minCompareColumnWidth {
+		for i, r := range results {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "== %s (%s)\n%s\n", r.model, r.stats, r.text)
+		}
+		return nil
+	}
+
+	columns := make([][]string, len(results))
+	height := 0
+	for i, r := range results {
+		columns[i] = append([]string{r.model, r.stats, strings.Repeat("-", width)}, wrapLines(r.text, width)...)
+		height = max(height, len(columns[i]))
+	}
+	for row := 0; row < height; row++ {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			var cell string
+			if row < len(column) {
+				cell = runewidth.Truncate(column[row], width, "…")
+			}
+			cells[i] = runewidth.FillRight(cell, width)
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, compareColumnGap), " "))
+	}
+	return nil
+}
+
+// wrapLines soft-wraps text to width the same way the streamed message is
+// displayed.
+func wrapLines(text string, width int) []string {
+	var buf strings.Builder
+	ww := newWrapWriter(&buf, width)
+	// Writes to a strings.Builder can't fail.
+	_ = ww.WriteString(text)
+	_ = ww.Flush()
+	return strings.Split(buf.String(), "\n")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRunCompare(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	client, api := newTestClient(t, "Add a.txt", "Create a.txt\n\nIt holds one line.")
+	opts := testRunOptions(client)
+	opts.compareModels = []string{"gpt-4o", "gpt-4o-mini"}
+	var err error
+	out := capture(t, &os.Stdout, func() { err = run(opts) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, model := range opts.compareModels {
+		if got := api.requests[i].Model; got != model {
+			t.Errorf("request %d used %s, want %s", i, got, model)
+		}
+	}
+	// Without a terminal the models are shown one after another.
+	for _, want := range []string{"== gpt-4o (", "Add a.txt", "== gpt-4o-mini (", "Create a.txt\n\nIt holds one line."} {
+		if !strings.Contains(out, want) {
+			t.Errorf("the comparison doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "== gpt-4o-mini") < strings.Index(out, "Add a.txt") {
+		t.Errorf("the models are out of order:\n%s", out)
+	}
+	if err := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD").Run(); err == nil {
+		t.Error("compare made a commit")
+	}
+}
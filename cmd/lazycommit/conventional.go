@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	scopeRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	// conventionalHeaderRe matches "type(scope)!: " at the start of a subject.
+	conventionalHeaderRe = regexp.MustCompile(`^([a-z]+)(\([^)]*\))?(!)?: `)
+	// scopeInvalidRe matches runs of characters not allowed in a scope.
+	scopeInvalidRe = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func validateScope(scope string) error {
+	if !scopeRe.MatchString(scope) {
+		return fmt.Errorf("invalid scope %q: must be lowercase alphanumeric with dashes", scope)
+	}
+	return nil
+}
+
+// conventionalPrompt returns the instruction for Conventional Commit output.
+// A non-empty scope is forced onto the message.
+func conventionalPrompt(scope string) string {
+	lines := []string{
+		"Format the commit message as a Conventional Commit: `<type>(<scope>): <subject>`.",
+		"Use one of these types: " + strings.Join(conventionalTypes, ", ") + ".",
+		"The scope is optional and names the area of the codebase affected.",
+		"If the diff makes a breaking API change, put ! after the type or scope " +
+			"and add a `BREAKING CHANGE: <description>` footer explaining it.",
+	}
+	if scope != "" {
+		lines = append(lines, fmt.Sprintf("Use exactly %q as the scope.", scope))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// forceScope rewrites the scope of a Conventional Commit subject. Messages
+// without a Conventional Commit header are returned unchanged.
+func forceScope(msg string, scope string) string {
+	m := conventionalHeaderRe.FindStringSubmatchIndex(msg)
+	if m == nil {
+		return msg
+	}
+	typ := msg[m[2]:m[3]]
+	var bang string
+	if m[6] >= 0 {
+		bang = "!"
+	}
+	return fmt.Sprintf("%s(%s)%s: %s", typ, scope, bang, msg[m[1]:])
+}
+
+// breakingFooterRe matches a Conventional Commit breaking change footer.
+var breakingFooterRe = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: `)
+
+// isBreaking reports whether msg is marked as a breaking change, by a ! in
+// its header or a BREAKING CHANGE footer.
+func isBreaking(msg string) bool {
+	m := conventionalHeaderRe.FindStringSubmatchIndex(msg)
+	return (m != nil && m[6] >= 0) || breakingFooterRe.MatchString(msg)
+}
+
+// markBreaking adds both the ! and, if missing, a BREAKING CHANGE footer
+// that repeats the subject. Messages without a Conventional Commit header
+// are returned unchanged.
+func markBreaking(msg string) string {
+	m := conventionalHeaderRe.FindStringSubmatchIndex(msg)
+	if m == nil {
+		return msg
+	}
+	if m[6] < 0 {
+		msg = msg[:m[1]-2] + "!" + msg[m[1]-2:]
+		m = conventionalHeaderRe.FindStringSubmatchIndex(msg)
+	}
+	if breakingFooterRe.MatchString(msg) {
+		return msg
+	}
+	subject, _, _ := strings.Cut(msg[m[1]:], "\n")
+	body, trailers := splitTrailers(msg)
+	body += "\n\nBREAKING CHANGE: " + subject
+	if len(trailers) > 0 {
+		body += "\n\n" + strings.Join(trailers, "\n")
+	}
+	return body
+}
+
+// unmarkBreaking removes the ! and any BREAKING CHANGE footer paragraph.
+func unmarkBreaking(msg string) string {
+	if m := conventionalHeaderRe.FindStringSubmatchIndex(msg); m != nil && m[6] >= 0 {
+		msg = msg[:m[6]] + msg[m[7]:]
+	}
+	paragraphs := strings.Split(msg, "\n\n")
+	kept := paragraphs[:0]
+	for _, p := range paragraphs {
+		if !breakingFooterRe.MatchString(p) {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "\n\n")
+}
+
+// packageScope returns a scope naming the Go package with the most changed
+// lines in diff, breaking ties alphabetically. It returns "" when no Go
+// files outside the repository root changed.
+func packageScope(diff string) string {
+	changed := map[string]int{}
+	for _, f := range splitDiff(diff) {
+		if !strings.HasSuffix(f.path, ".go") {
+			continue
+		}
+		dir := path.Dir(f.path)
+		if dir == "." {
+			continue
+		}
+		changed[dir] += changedLines(f.text)
+	}
+
+	dirs := make([]string, 0, len(changed))
+	for dir := range changed {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if changed[dirs[i]] != changed[dirs[j]] {
+			return changed[dirs[i]] > changed[dirs[j]]
+		}
+		return dirs[i] < dirs[j]
+	})
+	for _, dir := range dirs {
+		scope := strings.Trim(scopeInvalidRe.ReplaceAllString(strings.ToLower(path.Base(dir)), "-"), "-")
+		if scope != "" {
+			return scope
+		}
+	}
+	return ""
+}
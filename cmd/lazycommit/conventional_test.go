@@ -0,0 +1,156 @@
+package main
+
+import "testing"
+
+func TestValidateScope(t *testing.T) {
+	for _, scope := range []string{"api", "cli-flags", "v2"} {
+		if err := validateScope(scope); err != nil {
+			t.Errorf("validateScope(%q) = %v", scope, err)
+		}
+	}
+	for _, scope := range []string{"", "API", "cli flags", "-api", "api-"} {
+		if err := validateScope(scope); err == nil {
+			t.Errorf("validateScope(%q) succeeded, want an error", scope)
+		}
+	}
+}
+
+func TestForceScope(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{msg: "fix: typo", want: "fix(docs): typo"},
+		{msg: "fix(api): typo\n\nBody.", want: "fix(docs): typo\n\nBody."},
+		{msg: "feat(api)!: drop v1", want: "feat(docs)!: drop v1"},
+		{msg: "Fix typo", want: "Fix typo"},
+	}
+	for _, tt := range tests {
+		if got := forceScope(tt.msg, "docs"); got != tt.want {
+			t.Errorf("forceScope(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestMarkBreaking(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{
+			name: "adds bang and footer",
+			msg:  "feat(api): drop v1\n\nBody.",
+			want: "feat(api)!: drop v1\n\nBody.\n\nBREAKING CHANGE: drop v1",
+		},
+		{
+			name: "footer goes before trailers",
+			msg:  "feat: drop v1\n\nRefs: #1",
+			want: "feat!: drop v1\n\nBREAKING CHANGE: drop v1\n\nRefs: #1",
+		},
+		{
+			name: "existing footer is kept",
+			msg:  "feat: drop v1\n\nBREAKING CHANGE: v1 clients must upgrade",
+			want: "feat!: drop v1\n\nBREAKING CHANGE: v1 clients must upgrade",
+		},
+		{
+			name: "not conventional",
+			msg:  "Drop v1",
+			want: "Drop v1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := markBreaking(tt.msg)
+			if got != tt.want {
+				t.Errorf("markBreaking() = %q, want %q", got, tt.want)
+			}
+			if tt.msg != "Drop v1" && !isBreaking(got) {
+				t.Errorf("isBreaking(%q) = false", got)
+			}
+		})
+	}
+}
+
+func TestUnmarkBreaking(t *testing.T) {
+	msg := "feat(api)!: drop v1\n\nBody.\n\nBREAKING CHANGE: drop v1\n\nRefs: #1"
+	want := "feat(api): drop v1\n\nBody.\n\nRefs: #1"
+	got := unmarkBreaking(msg)
+	if got != want {
+		t.Errorf("unmarkBreaking() = %q, want %q", got, want)
+	}
+	if isBreaking(got) {
+		t.Errorf("isBreaking(%q) = true", got)
+	}
+}
+
+func TestPackageScope(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want string
+	}{
+		{
+			name: "most changed lines",
+			diff: "diff --git a/pkg/api/a.go b/pkg/api/a.go\n+a\ndiff --git a/internal/store/s.go b/internal/store/s.go\n+a\n+b\n",
+			want: "store",
+		},
+		{
+			name: "ties are alphabetical",
+			diff: "diff --git a/pkg/zeta/a.go b/pkg/zeta/a.go\n+a\ndiff --git a/pkg/alpha/a.go b/pkg/alpha/a.go\n+a\n",
+			want: "alpha",
+		},
+		{
+			name: "files in one package add up",
+			diff: "diff --git a/a/x.go b/a/x.go\n+1\n+2\ndiff --git a/b/x.go b/b/x.go\n+1\n+2\n+3\ndiff --git a/a/y.go b/a/y.go\n+3\n+4\n",
+			want: "a",
+		},
+		{
+			name: "root and non-Go files are ignored",
+			diff: "diff --git a/main.go b/main.go\n+a\n+b\ndiff --git a/docs/x.md b/docs/x.md\n+a\n+b\n",
+			want: "",
+		},
+		{
+			name: "directory names are sanitized",
+			diff: "diff --git a/cmd/Lazy_Commit/main.go b/cmd/Lazy_Commit/main.go\n+a\n",
+			want: "lazy-commit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := packageScope(tt.diff); got != tt.want {
+				t.Errorf("packageScope() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunScopePrecedence(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		want  string
+	}{
+		{name: "derived from the package", want: "feat(store): add a store"},
+		{name: "explicit scope wins", scope: "db", want: "feat(db): add a store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestRepo(t)
+			writeTestFile(t, "internal/store/store.go", "package store\n")
+			testGit(t, "add", ".")
+
+			client, _ := newTestClient(t, "feat: add a store")
+			opts := testRunOptions(client)
+			opts.conventional = true
+			opts.scopeFromPackage = true
+			opts.scope = tt.scope
+			if err := run(opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := testGit(t, "log", "-1", "--format=%s"); got != tt.want {
+				t.Errorf("subject = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
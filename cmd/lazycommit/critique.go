@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+var confidenceRe = regexp.MustCompile(`\b(100|[0-9]{1,2})\b`)
+
+const critiquePrompt = "You review commit messages. Rate from 0 to 100 how confident " +
+	"you are that the commit message accurately and completely describes the " +
+	"diff. Reply with only the number."
+
+// rateConfidence asks model how well msg describes diff, on a 0-100 scale.
+func rateConfidence(ctx context.Context, client *openai.Client, model string, diff string, msg string) (int, error) {
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:       model,
+		Temperature: 0,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: critiquePrompt},
+			{Role: openai.ChatMessageRoleUser, Content: "Diff:\n" + diff},
+			{Role: openai.ChatMessageRoleUser, Content: "Commit message:\n" + msg},
+		},
+	})
+	if err != nil {
+		return 0, explainAPIError(err)
+	}
+	if len(resp.Choices) == 0 {
+		return 0, fmt.Errorf("%w: no confidence rating returned", errAPI)
+	}
+	reply := resp.Choices[0].Message.Content
+	m := confidenceRe.FindString(reply)
+	if m == "" {
+		return 0, fmt.Errorf("%w: unexpected confidence rating %q", errAPI, reply)
+	}
+	return strconv.Atoi(m)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// fileDiff is the portion of a git diff for a single file.
+type fileDiff struct {
+	path string
+	text string
+}
+
+// splitDiff splits a git diff into per-file sections.
+func splitDiff(diff string) []fileDiff {
+	var files []fileDiff
+	for _, section := range strings.SplitAfter(diff, "\n") {
+		if strings.HasPrefix(section, "diff --git ") || len(files) == 0 {
+			files = append(files, fileDiff{path: diffPath(section)})
+		}
+		files[len(files)-1].text += section
+	}
+	if len(files) == 1 && files[0].text == "" {
+		return nil
+	}
+	return files
+}
+
+// diffPath returns the post-image path from a "diff --git a/x b/y" header.
+func diffPath(header string) string {
+	header = strings.TrimSpace(header)
+	if i := strings.LastIndex(header, " b/"); i >= 0 {
+		return header[i+3:]
+	}
+	return ""
+}
+
+func joinDiff(files []fileDiff) string {
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f.text)
+	}
+	return b.String()
+}
+
+// changedLines counts the added and removed lines in diff.
+func changedLines(diff string) int {
+	var n int
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			n++
+		}
+	}
+	return n
+}
+
+// filterDiff keeps the files for which keep returns true and returns the
+// paths of the rest.
+func filterDiff(files []fileDiff, keep func(path string) bool) (kept []fileDiff, omitted []string) {
+	for _, f := range files {
+		if keep(f.path) {
+			kept = append(kept, f)
+		} else {
+			omitted = append(omitted, f.path)
+		}
+	}
+	return kept, omitted
+}
+
+// hasExtension reports whether path ends in one of exts. Extensions are
+// matched case-insensitively with or without a leading dot; a path with no
+// extension never matches.
+func hasExtension(path string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return false
+	}
+	for _, e := range exts {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// lockfiles are generated dependency lock files, matched by base name.
+var lockfiles = []string{
+	"go.sum",
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"Cargo.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"Gemfile.lock",
+	"composer.lock",
+}
+
+func isLockfile(path string) bool {
+	return slices.Contains(lockfiles, filepath.Base(path))
+}
+
+// omittedNote lists files whose diffs were left out of the prompt.
+func omittedNote(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	return "\nThese files also changed, but their diffs are omitted:\n- " +
+		strings.Join(paths, "\n- ") + "\n"
+}
+
+// diffAlgorithms are the values git accepts for --diff-algorithm.
+var diffAlgorithms = []string{"myers", "minimal", "patience", "histogram"}
+
+func validateDiffAlgorithm(algorithm string) error {
+	if !slices.Contains(diffAlgorithms, algorithm) {
+		return fmt.Errorf("invalid diff algorithm %q: must be one of %s",
+			algorithm, strings.Join(diffAlgorithms, ", "))
+	}
+	return nil
+}
+
+// diffFilterStatuses are the file status letters git accepts in
+// --diff-filter. Lowercase letters exclude a status instead.
+const diffFilterStatuses = "ACDMRTUXB"
+
+func validateDiffFilter(filter string) error {
+	for _, r := range filter {
+		if r != '*' && !strings.ContainsRune(diffFilterStatuses+strings.ToLower(diffFilterStatuses), r) {
+			return fmt.Errorf("invalid diff filter %q: %q is not one of %s, their lowercase forms or *",
+				filter, r, diffFilterStatuses)
+		}
+	}
+	return nil
+}
+
+// stripDiffHeaders replaces the header of each file in diff, from its
+// "diff --git" line to its first hunk, with a single "file: path" line.
+// Additions, deletions, renames and mode changes are noted on that line.
+func stripDiffHeaders(diff string) string {
+	var b strings.Builder
+	for _, f := range splitDiff(diff) {
+		if !strings.HasPrefix(f.text, "diff --git ") {
+			b.WriteString(f.text)
+			continue
+		}
+		lines := strings.SplitAfter(f.text, "\n")
+		var notes []string
+		var renamedFrom string
+		i := 0
+		for ; i < len(lines); i++ {
+			line := strings.TrimSuffix(lines[i], "\n")
+			if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "Binary files ") {
+				break
+			}
+			switch {
+			case strings.HasPrefix(line, "new file mode "):
+				notes = append(notes, "added")
+			case strings.HasPrefix(line, "deleted file mode "):
+				notes = append(notes, "deleted")
+			case strings.HasPrefix(line, "rename from "):
+				renamedFrom = strings.TrimPrefix(line, "rename from ")
+			case strings.HasPrefix(line, "new mode "):
+				notes = append(notes, "mode "+strings.TrimPrefix(line, "new mode "))
+			}
+		}
+		b.WriteString("file: ")
+		if renamedFrom != "" {
+			b.WriteString(renamedFrom + " -> ")
+		}
+		b.WriteString(f.path)
+		if len(notes) > 0 {
+			b.WriteString(" (" + strings.Join(notes, ", ") + ")")
+		}
+		b.WriteString("\n")
+		b.WriteString(strings.Join(lines[i:], ""))
+	}
+	return b.String()
+}
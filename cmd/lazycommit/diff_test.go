@@ -0,0 +1,151 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitDiff(t *testing.T) {
+	diff := "diff --git a/a.go b/a.go\n+a\ndiff --git a/old.go b/new.go\n-b\n"
+	files := splitDiff(diff)
+	if len(files) != 2 {
+		t.Fatalf("splitDiff() returned %d files, want 2", len(files))
+	}
+	if files[0].path != "a.go" || files[0].text != "diff --git a/a.go b/a.go\n+a\n" {
+		t.Errorf("files[0] = %+v", files[0])
+	}
+	if files[1].path != "new.go" || files[1].text != "diff --git a/old.go b/new.go\n-b\n" {
+		t.Errorf("files[1] = %+v", files[1])
+	}
+	if got := joinDiff(files); got != diff {
+		t.Errorf("joinDiff(splitDiff()) = %q, want %q", got, diff)
+	}
+	if files := splitDiff(""); files != nil {
+		t.Errorf("splitDiff(\"\") = %+v, want nil", files)
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	diff := "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1,2 +1,2 @@\n ctx\n-old\n+new\n+more\n"
+	if got := changedLines(diff); got != 3 {
+		t.Errorf("changedLines() = %d, want 3", got)
+	}
+}
+
+func TestHasExtension(t *testing.T) {
+	tests := []struct {
+		path string
+		exts []string
+		want bool
+	}{
+		{path: "main.go", exts: []string{"go"}, want: true},
+		{path: "main.go", exts: []string{".go"}, want: true},
+		{path: "README.MD", exts: []string{"md", "go"}, want: true},
+		{path: "main.go", exts: []string{" GO "}, want: true},
+		{path: "main.go", exts: []string{"md"}, want: false},
+		{path: "Makefile", exts: []string{""}, want: false},
+		{path: "archive.tar.gz", exts: []string{"tar"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := hasExtension(tt.path, tt.exts); got != tt.want {
+			t.Errorf("hasExtension(%q, %q) = %v, want %v", tt.path, tt.exts, got, tt.want)
+		}
+	}
+}
+
+func TestFilterDiff(t *testing.T) {
+	files := splitDiff("diff --git a/a.go b/a.go\n+a\ndiff --git a/b.md b/b.md\n+b\ndiff --git a/c.go b/c.go\n+c\n")
+	kept, omitted := filterDiff(files, func(path string) bool { return hasExtension(path, []string{"go"}) })
+	if got := joinDiff(kept); got != "diff --git a/a.go b/a.go\n+a\ndiff --git a/c.go b/c.go\n+c\n" {
+		t.Errorf("kept %q", got)
+	}
+	if !slices.Equal(omitted, []string{"b.md"}) {
+		t.Errorf("omitted %q, want [b.md]", omitted)
+	}
+	if want := "\nThese files also changed, but their diffs are omitted:\n- b.md\n"; omittedNote(omitted) != want {
+		t.Errorf("omittedNote() = %q, want %q", omittedNote(omitted), want)
+	}
+	if omittedNote(nil) != "" {
+		t.Errorf("omittedNote(nil) = %q, want \"\"", omittedNote(nil))
+	}
+}
+
+func TestIsLockfile(t *testing.T) {
+	tests := map[string]bool{
+		"go.sum":                true,
+		"web/package-lock.json": true,
+		"Cargo.lock":            true,
+		"go.mod":                false,
+		"docs/yarn.lock.md":     false,
+	}
+	for path, want := range tests {
+		if got := isLockfile(path); got != want {
+			t.Errorf("isLockfile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestValidateDiffFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr bool
+	}{
+		{filter: ""},
+		{filter: "AM"},
+		{filter: "d"},
+		{filter: "ACDMRTUXB*"},
+		{filter: "Z", wantErr: true},
+		{filter: "A,M", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := validateDiffFilter(tt.filter); (err != nil) != tt.wantErr {
+			t.Errorf("validateDiffFilter(%q) error = %v, wantErr %v", tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStripDiffHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want string
+	}{
+		{
+			name: "modified",
+			diff: "diff --git a/a.go b/a.go\nindex 1111111..2222222 100644\n--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-old\n+new\n",
+			want: "file: a.go\n@@ -1 +1 @@\n-old\n+new\n",
+		},
+		{
+			name: "added",
+			diff: "diff --git a/a.go b/a.go\nnew file mode 100644\nindex 0000000..2222222\n--- /dev/null\n+++ b/a.go\n@@ -0,0 +1 @@\n+new\n",
+			want: "file: a.go (added)\n@@ -0,0 +1 @@\n+new\n",
+		},
+		{
+			name: "deleted",
+			diff: "diff --git a/a.go b/a.go\ndeleted file mode 100644\nindex 1111111..0000000\n--- a/a.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n",
+			want: "file: a.go (deleted)\n@@ -1 +0,0 @@\n-old\n",
+		},
+		{
+			name: "renamed with mode change",
+			diff: "diff --git a/old.sh b/new.sh\nold mode 100644\nnew mode 100755\nsimilarity index 100%\nrename from old.sh\nrename to new.sh\n",
+			want: "file: old.sh -> new.sh (mode 100755)\n",
+		},
+		{
+			name: "binary",
+			diff: "diff --git a/a.png b/a.png\nindex 1111111..2222222 100644\nBinary files a/a.png and b/a.png differ\n",
+			want: "file: a.png\nBinary files a/a.png and b/a.png differ\n",
+		},
+		{
+			name: "several files",
+			diff: "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n+a\ndiff --git a/b.go b/b.go\n--- a/b.go\n+++ b/b.go\n@@ -1 +1 @@\n+b\n",
+			want: "file: a.go\n@@ -1 +1 @@\n+a\nfile: b.go\n@@ -1 +1 @@\n+b\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripDiffHeaders(tt.diff); got != tt.want {
+				t.Errorf("stripDiffHeaders() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/coder/pretty"
+	"github.com/mattn/go-runewidth"
+	"github.com/muesli/termenv"
+)
+
+// defaultTerminalWidth is assumed when $COLUMNS doesn't give the width.
+const defaultTerminalWidth = 80
+
+// terminalWidth returns the width of stdout, or 0 when it isn't a terminal.
+// termenv decides whether it is one, the same way it picks the color
+// profile, and $COLUMNS gives the width.
+func terminalWidth() int {
+	if termenv.NewOutput(os.Stdout).ColorProfile() == termenv.Ascii {
+		return 0
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return defaultTerminalWidth
+}
+
+// styledWriter applies a pretty formatter to everything written to w.
+type styledWriter struct {
+	w     io.Writer
+	style pretty.Formatter
+}
+
+func (sw styledWriter) Write(p []byte) (int, error) {
+	pretty.Fprint(sw.w, sw.style, string(p))
+	return len(p), nil
+}
+
+// wrapWriter soft-wraps streamed text at width columns, breaking between
+// words. Fenced and indented code lines are left unwrapped unless wrapCode
+// is set. It only affects what is displayed. A width of 0 disables wrapping.
+type wrapWriter struct {
+	w        io.Writer
+	width    int
+	wrapCode bool
+	col      int
+	// spaces and word are held back until we know whether the word fits on
+	// the current line.
+	spaces strings.Builder
+	word   strings.Builder
+
+	// lineStart holds the start of a line until it is known to be code.
+	lineStart strings.Builder
+	mode      lineMode
+	inFence   bool
+}
+
+type lineMode int
+
+const (
+	lineUndecided lineMode = iota
+	lineProse
+	lineCode
+)
+
+func newWrapWriter(w io.Writer, width int) *wrapWriter {
+	return &wrapWriter{w: w, width: width}
+}
+
+func (ww *wrapWriter) WriteString(s string) error {
+	if ww.width <= 0 {
+		_, err := io.WriteString(ww.w, s)
+		return err
+	}
+	// Code is written in runs rather than rune by rune.
+	var code strings.Builder
+	flushCode := func() error {
+		if code.Len() == 0 {
+			return nil
+		}
+		_, err := io.WriteString(ww.w, code.String())
+		code.Reset()
+		return err
+	}
+	for _, r := range s {
+		switch ww.mode {
+		case lineCode:
+			code.WriteRune(r)
+			if r == '\n' {
+				ww.mode = lineUndecided
+			}
+			continue
+		case lineProse:
+			if err := ww.writeProse(r); err != nil {
+				return err
+			}
+			if r == '\n' {
+				ww.mode = lineUndecided
+			}
+			continue
+		}
+
+		if r == '\n' {
+			// A line too short to classify, such as a blank one.
+			code.WriteString(ww.lineStart.String() + "\n")
+			ww.lineStart.Reset()
+			ww.col = 0
+			continue
+		}
+		ww.lineStart.WriteRune(r)
+		ww.mode = ww.classify(ww.lineStart.String())
+		switch ww.mode {
+		case lineCode:
+			code.WriteString(ww.lineStart.String())
+			ww.lineStart.Reset()
+		case lineProse:
+			if err := flushCode(); err != nil {
+				return err
+			}
+			start := ww.lineStart.String()
+			ww.lineStart.Reset()
+			for _, r := range start {
+				if err := ww.writeProse(r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flushCode()
+}
+
+// classify decides whether the line starting with start is code, returning
+// lineUndecided until it can tell.
+func (ww *wrapWriter) classify(start string) lineMode {
+	const fence = "```"
+	switch {
+	case ww.wrapCode:
+		return lineProse
+	case strings.HasPrefix(start, fence):
+		ww.inFence = !ww.inFence
+		return lineCode
+	case strings.HasPrefix(fence, start):
+		return lineUndecided
+	case ww.inFence, start == "\t", start == "    ":
+		return lineCode
+	case strings.Trim(start, " ") == "":
+		return lineUndecided
+	}
+	return lineProse
+}
+
+func (ww *wrapWriter) writeProse(r rune) error {
+	switch {
+	case r == '\n':
+		if err := ww.flushWord(); err != nil {
+			return err
+		}
+		ww.spaces.Reset()
+		ww.col = 0
+		_, err := io.WriteString(ww.w, "\n")
+		return err
+	case unicode.IsSpace(r):
+		if err := ww.flushWord(); err != nil {
+			return err
+		}
+		ww.spaces.WriteRune(r)
+	default:
+		ww.word.WriteRune(r)
+	}
+	return nil
+}
+
+// Flush writes any partially buffered word or line.
+func (ww *wrapWriter) Flush() error {
+	if ww.lineStart.Len() > 0 {
+		start := ww.lineStart.String()
+		ww.lineStart.Reset()
+		if _, err := io.WriteString(ww.w, start); err != nil {
+			return err
+		}
+	}
+	return ww.flushWord()
+}
+
+func (ww *wrapWriter) flushWord() error {
+	if ww.word.Len() == 0 {
+		return nil
+	}
+	spaces, word := ww.spaces.String(), ww.word.String()
+	ww.spaces.Reset()
+	ww.word.Reset()
+
+	n := runewidth.StringWidth(word)
+	if ww.col > 0 && ww.col+len(spaces)+n > ww.width {
+		spaces = "\n"
+		ww.col = 0
+	} else {
+		ww.col += len(spaces)
+	}
+	ww.col += n
+	_, err := io.WriteString(ww.w, spaces+word)
+	return err
+}
+
+// sectionWriter sends the subject, up to the first blank line, to subject
+// and everything after it to body. The blank line may be split across
+// writes.
+type sectionWriter struct {
+	subject io.Writer
+	body    io.Writer
+	inBody  bool
+	// lastNewline is whether the previous byte written was a newline.
+	lastNewline bool
+}
+
+func (sw *sectionWriter) Write(p []byte) (int, error) {
+	if sw.inBody {
+		return sw.body.Write(p)
+	}
+	for i, b := range p {
+		if b != '\n' {
+			sw.lastNewline = false
+			continue
+		}
+		if !sw.lastNewline {
+			sw.lastNewline = true
+			continue
+		}
+		// The second newline starts the body.
+		sw.inBody = true
+		if _, err := sw.subject.Write(p[:i]); err != nil {
+			return 0, err
+		}
+		if _, err := sw.body.Write(p[i:]); err != nil {
+			return i, err
+		}
+		return len(p), nil
+	}
+	return sw.subject.Write(p)
+}
+
+// chunkWriter batches small writes so styled output is emitted a few words
+// at a time rather than per token, which avoids flicker. Buffered text is
+// written up to its last whitespace, or entirely once interval has passed
+// since the previous write to w.
+type chunkWriter struct {
+	w        io.Writer
+	interval time.Duration
+	// now is the clock, replaceable for testing.
+	now       func() time.Time
+	buf       []byte
+	lastFlush time.Time
+}
+
+func newChunkWriter(w io.Writer, interval time.Duration) *chunkWriter {
+	return &chunkWriter{w: w, interval: interval, now: time.Now, lastFlush: time.Now()}
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	n := len(cw.buf)
+	if cw.now().Sub(cw.lastFlush) < cw.interval {
+		n = bytes.LastIndexAny(cw.buf, " \t\n") + 1
+	}
+	if n == 0 {
+		return len(p), nil
+	}
+	if err := cw.flush(n); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes everything buffered.
+func (cw *chunkWriter) Flush() error {
+	return cw.flush(len(cw.buf))
+}
+
+func (cw *chunkWriter) flush(n int) error {
+	if n == 0 {
+		return nil
+	}
+	_, err := cw.w.Write(cw.buf[:n])
+	cw.buf = append(cw.buf[:0], cw.buf[n:]...)
+	cw.lastFlush = cw.now()
+	return err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrapWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		width    int
+		wrapCode bool
+		in       string
+		want     string
+	}{
+		{
+			name:  "disabled",
+			width: 0,
+			in:    "a long line that would otherwise wrap",
+			want:  "a long line that would otherwise wrap",
+		},
+		{
+			name:  "wraps between words",
+			width: 10,
+			in:    "fix: wrap long lines\n\nthe quick brown fox",
+			want:  "fix: wrap\nlong lines\n\nthe quick\nbrown fox",
+		},
+		{
+			name:  "long words are not split",
+			width: 5,
+			in:    "a verylongword b",
+			want:  "a\nverylongword\nb",
+		},
+		{
+			name:  "wide characters",
+			width: 10,
+			in:    "日本語 日本語",
+			want:  "日本語\n日本語",
+		},
+		{
+			name:  "indented code is not wrapped",
+			width: 10,
+			in:    "example:\n\n    go test ./... -run TestWrapWriter\n\tgo vet ./... -all\n",
+			want:  "example:\n\n    go test ./... -run TestWrapWriter\n\tgo vet ./... -all\n",
+		},
+		{
+			name:  "fenced code is not wrapped",
+			width: 10,
+			in:    "run this:\n```\nlazycommit --dry-run --model gpt-4o\n```\nthen commit it",
+			want:  "run this:\n```\nlazycommit --dry-run --model gpt-4o\n```\nthen\ncommit it",
+		},
+		{
+			name:     "wrapCode wraps code too",
+			width:    10,
+			wrapCode: true,
+			in:       "    go test ./... -run",
+			want:     "    go\ntest ./...\n-run",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Stream the input a rune at a time, as tokens arrive, and
+			// all at once.
+			for _, chunked := range []bool{true, false} {
+				var b strings.Builder
+				ww := newWrapWriter(&b, tt.width)
+				ww.wrapCode = tt.wrapCode
+				if chunked {
+					for _, r := range tt.in {
+						if err := ww.WriteString(string(r)); err != nil {
+							t.Fatal(err)
+						}
+					}
+				} else if err := ww.WriteString(tt.in); err != nil {
+					t.Fatal(err)
+				}
+				if err := ww.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				if got := b.String(); got != tt.want {
+					t.Errorf("chunked=%v: got %q, want %q", chunked, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSectionWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  []string
+		subject string
+		body    string
+	}{
+		{
+			name:    "subject only",
+			writes:  []string{"fix: typo\n"},
+			subject: "fix: typo\n",
+		},
+		{
+			name:    "one write",
+			writes:  []string{"fix: typo\n\nBody.\n\nMore."},
+			subject: "fix: typo\n",
+			body:    "\nBody.\n\nMore.",
+		},
+		{
+			name:    "blank line split across writes",
+			writes:  []string{"fix: ", "typo\n", "\nBody", "."},
+			subject: "fix: typo\n",
+			body:    "\nBody.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subject, body strings.Builder
+			sw := &sectionWriter{subject: &subject, body: &body}
+			for _, s := range tt.writes {
+				if n, err := sw.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if subject.String() != tt.subject || body.String() != tt.body {
+				t.Errorf("got subject %q, body %q, want %q, %q", subject.String(), body.String(), tt.subject, tt.body)
+			}
+		})
+	}
+}
+
+func TestChunkWriter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	cw := newChunkWriter(&b, 100*time.Millisecond)
+	cw.now = func() time.Time { return now }
+	cw.lastFlush = now
+
+	write := func(s string) {
+		t.Helper()
+		if n, err := cw.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+
+	write("fi")
+	write("x: ty")
+	if got := b.String(); got != "fix: " {
+		t.Errorf("within the interval got %q, want text up to the last space", got)
+	}
+	write("po")
+	if got := b.String(); got != "fix: " {
+		t.Errorf("a partial word was written early: %q", got)
+	}
+	now = now.Add(200 * time.Millisecond)
+	write("s")
+	if got := b.String(); got != "fix: typos" {
+		t.Errorf("after the interval got %q, want everything", got)
+	}
+	write("\nBo")
+	if err := cw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "fix: typos\nBo" {
+		t.Errorf("after Flush got %q", got)
+	}
+}
+
+func TestTerminalWidthNotATerminal(t *testing.T) {
+	t.Setenv("COLUMNS", "120")
+	// Test output goes to a pipe, so nothing should be wrapped.
+	if got := terminalWidth(); got != 0 {
+		t.Errorf("terminalWidth() = %d when stdout isn't a terminal, want 0", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/coder/pretty"
+	"github.com/sashabaranov/go-openai"
+)
+
+// doctorCheck is one item of the doctor command's checklist.
+type doctorCheck struct {
+	name string
+	// critical checks make doctor exit nonzero when they fail.
+	critical bool
+	// run returns a short description of what was found.
+	run func() (string, error)
+	// fix is advice printed when the check fails.
+	fix string
+}
+
+// doctorChecks returns the checks for the doctor command. keyErr is the
+// error looking up key, if any. client is nil if no API key was found, in
+// which case the endpoint check is skipped.
+func doctorChecks(gitPath string, p provider, key string, keyErr error, client *openai.Client) []doctorCheck {
+	keyFix := "pass --openai-key or --op-item"
+	if p.keyEnv != "" {
+		keyFix = "set " + p.keyEnv + ", or " + keyFix
+	}
+	return []doctorCheck{
+		{
+			name:     "git",
+			critical: true,
+			run: func() (string, error) {
+				if err := setGitBinary(gitPath); err != nil {
+					return "", err
+				}
+				return gitOutput("--version")
+			},
+			fix: "install git, or point --git-path or $" + gitBinaryEnv + " at it",
+		},
+		{
+			name: "repository",
+			run: func() (string, error) {
+				dir, err := os.Getwd()
+				if err != nil {
+					return "", err
+				}
+				return findGitRoot(dir)
+			},
+			fix: "run lazycommit inside a git repository",
+		},
+		{
+			name:     "API key",
+			critical: p.keyEnv != "",
+			run: func() (string, error) {
+				switch {
+				case keyErr != nil:
+					return "", keyErr
+				case key == "" && p.keyEnv == "":
+					return "not needed by " + p.name, nil
+				case key == "":
+					return "", errMissingAPIKey
+				case p.validKey != nil && !p.validKey(key):
+					return "", fmt.Errorf("the key does not look like a valid %s key", p.name)
+				}
+				return "found", nil
+			},
+			fix: keyFix,
+		},
+		{
+			name:     "API endpoint",
+			critical: true,
+			run: func() (string, error) {
+				if client == nil {
+					return "", errors.New("skipped, no API key")
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				models, err := client.ListModels(ctx)
+				if err != nil {
+					return "", explainAPIError(err)
+				}
+				return fmt.Sprintf("reachable, %d models available", len(models.Models)), nil
+			},
+			fix: "check your network and --openai-base-url",
+		},
+		{
+			name:     "tokenizer",
+			critical: true,
+			run: func() (string, error) {
+				enc, err := getEncoder()
+				if err != nil {
+					return "", err
+				}
+				if _, _, err := enc.Encode("lazycommit"); err != nil {
+					return "", err
+				}
+				return "cl100k_base", nil
+			},
+			fix: "reinstall lazycommit",
+		},
+	}
+}
+
+// runDoctor runs checks, writing a checklist to w. It fails if any critical
+// check fails.
+func runDoctor(w io.Writer, checks []doctorCheck) error {
+	pass := pretty.FgColor(colorProfile.Color("#3FB950"))
+	fail := pretty.FgColor(colorProfile.Color("#F85149"))
+	warn := pretty.FgColor(colorProfile.Color("#D29922"))
+
+	var failed int
+	for _, c := range checks {
+		detail, err := c.run()
+		switch {
+		case err == nil:
+			pretty.Fprintf(w, pass, "[ok]   ")
+			fmt.Fprintf(w, "%s: %s\n", c.name, detail)
+			continue
+		case c.critical:
+			failed++
+			pretty.Fprintf(w, fail, "[fail] ")
+		default:
+			pretty.Fprintf(w, warn, "[warn] ")
+		}
+		fmt.Fprintf(w, "%s: %v\n       fix: %s\n", c.name, err, c.fix)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d critical checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/muesli/termenv"
+)
+
+func TestRunDoctor(t *testing.T) {
+	defer func(p termenv.Profile) { colorProfile = p }(colorProfile)
+	colorProfile = termenv.Ascii
+
+	ok := doctorCheck{name: "git", critical: true, run: func() (string, error) { return "git version 2.45.0", nil }}
+	warning := doctorCheck{name: "repository", run: func() (string, error) { return "", errors.New("not a git repository") }, fix: "run it in a repository"}
+	failure := doctorCheck{name: "API key", critical: true, run: func() (string, error) { return "", errMissingAPIKey }, fix: "set OPENAI_API_KEY"}
+
+	tests := []struct {
+		name    string
+		checks  []doctorCheck
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "all pass",
+			checks: []doctorCheck{ok},
+			want:   "[ok]   git: git version 2.45.0\n",
+		},
+		{
+			name:   "non-critical failures only warn",
+			checks: []doctorCheck{ok, warning},
+			want:   "[ok]   git: git version 2.45.0\n[warn] repository: not a git repository\n       fix: run it in a repository\n",
+		},
+		{
+			name:    "critical failures fail",
+			checks:  []doctorCheck{failure, warning, ok},
+			want:    "[fail] API key: " + errMissingAPIKey.Error() + "\n       fix: set OPENAI_API_KEY\n[warn] repository",
+			wantErr: "1 critical checks failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			err := runDoctor(&b, tt.checks)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("runDoctor() = %v, want %q", err, tt.wantErr)
+			}
+			if !strings.HasPrefix(b.String(), tt.want) {
+				t.Errorf("runDoctor() wrote\n%s\nwant it to start with\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDoctorAPIKeyCheck(t *testing.T) {
+	openAI, err := lookupProvider("openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := provider{name: "local"}
+	tests := []struct {
+		name     string
+		p        provider
+		key      string
+		keyErr   error
+		want     string
+		critical bool
+	}{
+		{name: "valid key", p: openAI, key: "sk-" + strings.Repeat("a", 48), want: "found", critical: true},
+		{name: "missing key", p: openAI, want: errMissingAPIKey.Error(), critical: true},
+		{name: "malformed key", p: openAI, key: "hunter2", want: "does not look like a valid openai key", critical: true},
+		{name: "lookup error", p: openAI, keyErr: errors.New("op: not signed in"), want: "op: not signed in", critical: true},
+		{name: "keyless provider", p: local, want: "not needed by local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var check doctorCheck
+			for _, c := range doctorChecks("", tt.p, tt.key, tt.keyErr, nil) {
+				if c.name == "API key" {
+					check = c
+				}
+			}
+			detail, err := check.run()
+			if err != nil {
+				detail = err.Error()
+			}
+			if !strings.Contains(detail, tt.want) || check.critical != tt.critical {
+				t.Errorf("API key check = %q, critical %v, want %q, critical %v", detail, check.critical, tt.want, tt.critical)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Exit codes, also reported as "code" in --json error output.
+const (
+	exitFailure   = 1
+	exitConfig    = 2
+	exitAPI       = 3
+	exitNoChanges = 4
+	exitLint      = 5
+)
+
+var (
+	errMissingAPIKey = errors.New("API key is not set")
+	errAPI           = errors.New("API request failed")
+	errNoChanges     = errors.New("nothing to commit")
+	errLint          = errors.New("commit message failed validation")
+)
+
+// exitCode maps err to the process exit code.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errMissingAPIKey):
+		return exitConfig
+	case errors.Is(err, errAPI):
+		return exitAPI
+	case errors.Is(err, errNoChanges):
+		return exitNoChanges
+	case errors.Is(err, errLint):
+		return exitLint
+	default:
+		return exitFailure
+	}
+}
+
+// writeJSONError writes err in the --json error format.
+func writeJSONError(w io.Writer, err error) error {
+	return json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}{
+		Error: err.Error(),
+		Code:  exitCode(err),
+	})
+}
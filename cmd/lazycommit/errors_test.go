@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: errors.New("boom"), want: exitFailure},
+		{err: errMissingAPIKey, want: exitConfig},
+		{err: fmt.Errorf("%w: 500", errAPI), want: exitAPI},
+		{err: fmt.Errorf("generate: %w", explainAPIError(errors.New("reset"))), want: exitAPI},
+		{err: fmt.Errorf("no staged changes, %w", errNoChanges), want: exitNoChanges},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// jsonError is the --json error format.
+type jsonError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+func TestWriteJSONError(t *testing.T) {
+	var b strings.Builder
+	if err := writeJSONError(&b, fmt.Errorf("%w: quota exceeded", errAPI)); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"error":"API request failed: quota exceeded","code":3}` + "\n"; b.String() != want {
+		t.Errorf("writeJSONError() wrote %q, want %q", b.String(), want)
+	}
+}
+
+func TestJSONModeAPIFailure(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	for _, status := range []int{401, 500} {
+		opts := testRunOptions(newErrorClient(t, status))
+		opts.jsonMode = true
+		var err error
+		stdout := capture(t, &os.Stdout, func() { err = run(opts) })
+		if err == nil {
+			t.Fatalf("status %d: run() succeeded", status)
+		}
+		// Nothing but the error may reach stdout.
+		if stdout != "" {
+			t.Errorf("status %d: run() wrote %q to stdout", status, stdout)
+		}
+
+		var b strings.Builder
+		if err := writeJSONError(&b, err); err != nil {
+			t.Fatal(err)
+		}
+		var got jsonError
+		if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+			t.Fatalf("status %d: invalid JSON %q: %v", status, b.String(), err)
+		}
+		if got.Code != exitAPI || !strings.HasPrefix(got.Error, "API request failed") {
+			t.Errorf("status %d: got %+v, want an API failure with code %d", status, got, exitAPI)
+		}
+		if rejected := strings.Contains(got.Error, "API key was rejected"); rejected != (status == 401) {
+			t.Errorf("status %d: error %q", status, got.Error)
+		}
+	}
+}
+
+func TestJSONModeMessage(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	client, _ := newTestClient(t, "Add a.txt")
+	opts := testRunOptions(client)
+	opts.jsonMode = true
+	var err error
+	stdout := capture(t, &os.Stdout, func() { err = run(opts) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"message":"Add a.txt","model":"gpt-4o"}` + "\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	if got := testGit(t, "rev-list", "--all", "--count"); got != "0" {
+		t.Errorf("--json committed: %s commits", got)
+	}
+}
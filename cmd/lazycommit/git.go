@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitTimeout bounds how long non-interactive git subprocesses may run, so
+// pathological repositories can't hang lazycommit. Zero disables the limit.
+var gitTimeout = 30 * time.Second
+
+// gitBinary is the git executable run for every git command.
+var gitBinary = "git"
+
+// gitBinaryEnv overrides gitBinary when --git-path isn't given.
+const gitBinaryEnv = "LAZYCOMMIT_GIT"
+
+// setGitBinary sets gitBinary to path, or to $LAZYCOMMIT_GIT if path is
+// empty, checking that it is executable.
+func setGitBinary(path string) error {
+	if path == "" {
+		path = os.Getenv(gitBinaryEnv)
+	}
+	if path == "" {
+		return nil
+	}
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return fmt.Errorf("git executable %q: %w", path, err)
+	}
+	gitBinary = resolved
+	return nil
+}
+
+// gitCommand returns a git command subject to gitTimeout. The returned
+// cancel function must be called once the command is done.
+func gitCommand(args ...string) (*exec.Cmd, context.CancelFunc) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if gitTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, gitTimeout)
+	}
+	return exec.CommandContext(ctx, gitBinary, args...), cancel
+}
+
+// hasStagedChanges reports whether the index differs from HEAD.
+func hasStagedChanges() (bool, error) {
+	cmd, cancel := gitCommand("diff", "--cached", "--quiet")
+	defer cancel()
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("check staged changes: %w", err)
+	}
+	return false, nil
+}
+
+// isRange reports whether ref is a base..head range rather than a commit.
+func isRange(ref string) bool {
+	return strings.Contains(ref, "..")
+}
+
+// resolveRange resolves both ends of a base..head range to commit hashes. An
+// empty end defaults to HEAD, as in git.
+func resolveRange(spec string) (string, error) {
+	base, head, _ := strings.Cut(spec, "..")
+	if base == "" {
+		base = "HEAD"
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+	baseHash, err := resolveRef(base)
+	if err != nil {
+		return "", fmt.Errorf("resolve %q: %w", base, err)
+	}
+	headHash, err := resolveRef(head)
+	if err != nil {
+		return "", fmt.Errorf("resolve %q: %w", head, err)
+	}
+	return baseHash + ".." + headHash, nil
+}
+
+// commitCount returns the number of commits in a base..head range.
+func commitCount(spec string) (int, error) {
+	cmd, cancel := gitCommand("rev-list", "--count", spec)
+	defer cancel()
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("count commits in %s: %w", spec, err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// commitSubjects returns the subjects of the commits in spec, oldest first.
+func commitSubjects(spec string) ([]string, error) {
+	output, err := gitOutput("log", "--reverse", "--format=%s", spec)
+	if err != nil {
+		return nil, fmt.Errorf("list commits in %s: %w", spec, err)
+	}
+	if output == "" {
+		return nil, nil
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+// gitOutput runs git with args and returns its trimmed output.
+func gitOutput(args ...string) (string, error) {
+	cmd, cancel := gitCommand(args...)
+	defer cancel()
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// remoteBranchesContaining returns the remote-tracking branches that
+// contain commit, i.e. where it has already been pushed.
+func remoteBranchesContaining(commit string) ([]string, error) {
+	output, err := gitOutput("branch", "--remotes", "--contains", commit, "--format=%(refname:short)")
+	if err != nil {
+		return nil, err
+	}
+	var branches []string
+	for _, b := range strings.Split(output, "\n") {
+		// Skip symbolic refs such as origin/HEAD.
+		if b != "" && !strings.HasSuffix(b, "/HEAD") {
+			branches = append(branches, b)
+		}
+	}
+	return branches, nil
+}
+
+// validateNewTag checks that name is a valid tag name that doesn't exist
+// yet, so --tag fails before committing rather than after.
+func validateNewTag(name string) error {
+	if _, err := gitOutput("check-ref-format", "refs/tags/"+name); err != nil {
+		return fmt.Errorf("invalid tag name %q", name)
+	}
+	if _, err := gitOutput("rev-parse", "--quiet", "--verify", "refs/tags/"+name); err == nil {
+		return fmt.Errorf("tag %q already exists", name)
+	}
+	return nil
+}
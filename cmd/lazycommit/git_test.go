@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHasStagedChanges(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+
+	check := func(want bool) {
+		t.Helper()
+		got, err := hasStagedChanges()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("hasStagedChanges() = %v, want %v", got, want)
+		}
+	}
+	check(false)
+	writeTestFile(t, "a.txt", "two\n")
+	check(false)
+	testGit(t, "add", ".")
+	check(true)
+}
+
+func TestRunStagingEmptiedWhileGenerating(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+	writeTestFile(t, "a.txt", "two\n")
+	testGit(t, "add", ".")
+
+	client, api := newTestClient(t, "Change a.txt")
+	// The server runs outside the test goroutine, so it can't use testGit.
+	api.onRequest = func() { _ = exec.Command("git", "reset", "--quiet").Run() }
+	err := run(testRunOptions(client))
+	if err == nil || !strings.Contains(err.Error(), "staging area became empty") {
+		t.Fatalf("run() = %v, want an empty staging area error", err)
+	}
+	if got := testGit(t, "log", "--format=%s"); got != "initial" {
+		t.Errorf("commits = %q, want only the initial one", got)
+	}
+}
+
+func TestGitBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub git is a shell script")
+	}
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	real, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stub logs the commands it runs and passes them on to git.
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "git.log")
+	stub := filepath.Join(dir, "git-stub")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$1\" >> %q\nexec %q \"$@\"\n", logFile, real)
+	if err := os.WriteFile(stub, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { gitBinary = old }(gitBinary)
+
+	t.Setenv(gitBinaryEnv, stub)
+	if err := setGitBinary(""); err != nil || gitBinary != stub {
+		t.Fatalf("setGitBinary(\"\") = %v, gitBinary = %q, want $%s", err, gitBinary, gitBinaryEnv)
+	}
+	// --git-path takes precedence over the environment.
+	t.Setenv(gitBinaryEnv, "/nonexistent/git")
+	if err := setGitBinary(stub); err != nil || gitBinary != stub {
+		t.Fatalf("setGitBinary(stub) = %v, gitBinary = %q", err, gitBinary)
+	}
+
+	client, _ := newTestClient(t, "Add a.txt")
+	if err := run(testRunOptions(client)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands := strings.Fields(string(b)); !slices.Contains(commands, "diff") || !slices.Contains(commands, "commit") {
+		t.Errorf("the stub ran %v, want the diff and commit to go through it", commands)
+	}
+
+	if err := setGitBinary("/nonexistent/git"); err == nil {
+		t.Error("setGitBinary() of a missing executable succeeded")
+	}
+}
+
+func TestRunTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		// want is the type of object the tag points at.
+		want string
+	}{
+		{name: "v1.0.0", want: "commit"},
+		{name: "v1.1.0", message: "Release 1.1.0", want: "tag"},
+	}
+	for _, tt := range tests {
+		initTestRepo(t)
+		writeTestFile(t, "a.txt", "one\n")
+		testGit(t, "add", ".")
+
+		client, _ := newTestClient(t, "Add a.txt")
+		opts := testRunOptions(client)
+		opts.tag = tt.name
+		opts.tagMessage = tt.message
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := testGit(t, "rev-parse", tt.name+"^{commit}"), testGit(t, "rev-parse", "HEAD"); got != want {
+			t.Errorf("%s points at %s, want the new commit %s", tt.name, got, want)
+		}
+		if got := testGit(t, "cat-file", "-t", tt.name); got != tt.want {
+			t.Errorf("%s is a %s, want a %s", tt.name, got, tt.want)
+		}
+
+		// An existing tag is refused before anything is committed.
+		writeTestFile(t, "b.txt", "two\n")
+		testGit(t, "add", ".")
+		if err := run(opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("run() with an existing tag = %v", err)
+		}
+		if got := testGit(t, "rev-list", "--count", "HEAD"); got != "1" {
+			t.Errorf("HEAD has %s commits after a refused tag, want 1", got)
+		}
+	}
+}
+
+func TestRunFixupAndSquash(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      func(opts *runOptions, target string)
+		want     string
+		requests int
+	}{
+		{
+			name:     "fixup",
+			set:      func(opts *runOptions, target string) { opts.fixup = target },
+			want:     "fixup! Add a.txt",
+			requests: 0,
+		},
+		{
+			name:     "squash",
+			set:      func(opts *runOptions, target string) { opts.squash = target },
+			want:     "squash! Add a.txt\n\nFix a typo in a.txt",
+			requests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestRepo(t)
+			writeTestFile(t, "a.txt", "on\n")
+			testGit(t, "add", ".")
+			testGit(t, "commit", "--quiet", "-m", "Add a.txt")
+			target := testGit(t, "rev-parse", "--short", "HEAD")
+			testGit(t, "commit", "--quiet", "--allow-empty", "-m", "Later work")
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+
+			client, api := newTestClient(t, "Fix a typo in a.txt")
+			opts := testRunOptions(client)
+			tt.set(&opts, target)
+			if err := run(opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := testGit(t, "log", "-1", "--format=%B"); got != tt.want {
+				t.Errorf("message = %q, want %q", got, tt.want)
+			}
+			if n := api.requestCount(); n != tt.requests {
+				t.Errorf("made %d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+
+	initTestRepo(t)
+	testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+	opts := testRunOptions(nil)
+	opts.fixup = "no-such-commit"
+	if err := run(opts); err == nil || !strings.Contains(err.Error(), `resolve "no-such-commit"`) {
+		t.Errorf("run() with a missing --fixup target = %v", err)
+	}
+}
+
+func TestRunRefStagedCheck(t *testing.T) {
+	for _, staged := range []bool{false, true} {
+		initTestRepo(t)
+		writeTestFile(t, "a.txt", "one\n")
+		testGit(t, "add", ".")
+		testGit(t, "commit", "--quiet", "-m", "initial")
+		writeTestFile(t, "a.txt", "two\n")
+		testGit(t, "commit", "--quiet", "-am", "wip")
+		if staged {
+			writeTestFile(t, "b.txt", "staged\n")
+			testGit(t, "add", "b.txt")
+		}
+
+		// The message describes HEAD but is committed with what is staged.
+		client, api := newTestClient(t, "Change a.txt")
+		opts := testRunOptions(client)
+		opts.ref = "HEAD"
+		err := run(opts)
+		if !staged {
+			if !errors.Is(err, errNoChanges) {
+				t.Errorf("run() with nothing staged = %v, want %v", err, errNoChanges)
+			}
+			if n := api.requestCount(); n != 0 {
+				t.Errorf("made %d requests with nothing staged to commit", n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(api.prompt(0), "+two") {
+			t.Errorf("the prompt doesn't describe HEAD:\n%s", api.prompt(0))
+		}
+		if got := testGit(t, "log", "-1", "--format=%s", "--name-only"); got != "Change a.txt\n\nb.txt" {
+			t.Errorf("HEAD = %q, want the staged b.txt with the message for HEAD", got)
+		}
+	}
+}
+
+func TestAmendPushedHead(t *testing.T) {
+	const question = "HEAD has been pushed to origin/main."
+	tests := []struct {
+		name    string
+		set     func(opts *runOptions)
+		wantErr string
+		asked   bool
+	}{
+		{name: "amending asks", set: func(opts *runOptions) {}, wantErr: "aborted", asked: true},
+		{name: "--force doesn't ask", set: func(opts *runOptions) { opts.force = true }},
+		{name: "--dry-run doesn't amend", set: func(opts *runOptions) { opts.dryRun = true }},
+		{name: "--json doesn't amend", set: func(opts *runOptions) { opts.jsonMode = true }},
+		{name: "--dump-prompt doesn't amend", set: func(opts *runOptions) { opts.dumpPrompt = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestRepo(t)
+			testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+			testGit(t, "commit", "--quiet", "-m", "wip")
+			testGit(t, "update-ref", "refs/remotes/origin/main", "HEAD")
+			// Decline if asked.
+			setStdin(t, "n\n")
+
+			client, _ := newTestClient(t, "Add a.txt")
+			opts := testRunOptions(client)
+			opts.amend = true
+			tt.set(&opts)
+			var err error
+			stderr := capture(t, &os.Stderr, func() {
+				capture(t, &os.Stdout, func() { err = run(opts) })
+			})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("run() = %v, want %q", err, tt.wantErr)
+			}
+			if got := strings.Contains(stderr, question); got != tt.asked {
+				t.Errorf("asked about the pushed HEAD: %v, want %v\n%s", got, tt.asked, stderr)
+			}
+		})
+	}
+}
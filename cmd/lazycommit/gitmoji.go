@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultGitmoji maps kinds of change to their gitmoji.
+var defaultGitmoji = map[string]string{
+	"feat":     "✨",
+	"fix":      "🐛",
+	"docs":     "📝",
+	"style":    "🎨",
+	"refactor": "♻️",
+	"perf":     "⚡️",
+	"test":     "✅",
+	"build":    "📦️",
+	"ci":       "👷",
+	"chore":    "🔧",
+	"revert":   "⏪️",
+}
+
+// loadEmojiMap reads a JSON object of change type to emoji and merges it
+// over defaultGitmoji.
+func loadEmojiMap(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read emoji map: %w", err)
+	}
+	var custom map[string]string
+	if err := json.Unmarshal(b, &custom); err != nil {
+		return nil, fmt.Errorf("emoji map %q must be a JSON object of type to emoji: %w", path, err)
+	}
+	m := make(map[string]string, len(defaultGitmoji)+len(custom))
+	for k, v := range defaultGitmoji {
+		m[k] = v
+	}
+	for k, v := range custom {
+		if strings.TrimSpace(k) == "" || strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("emoji map %q: types and emoji must not be empty", path)
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+func gitmojiPrompt(emoji map[string]string) string {
+	types := make([]string, 0, len(emoji))
+	for t := range emoji {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	lines := []string{"Start the subject with the emoji for the kind of change, followed by a space:"}
+	for _, t := range types {
+		lines = append(lines, fmt.Sprintf("- %s: %s", t, emoji[t]))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// shellCommand returns a command running command with the system shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// runPreHook runs the --pre-hook command, capturing its output. The output
+// is written to log when verbose is set or the command fails.
+func runPreHook(log io.Writer, command string, verbose bool) error {
+	var output bytes.Buffer
+	cmd := shellCommand(command)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if verbose || err != nil {
+		log.Write(output.Bytes())
+	}
+	if err != nil {
+		return fmt.Errorf("pre-hook %q failed: %w", command, err)
+	}
+	return nil
+}
+
+// postHookCommitEnv names the new commit's hash for the --post-hook command.
+const postHookCommitEnv = "LAZYCOMMIT_COMMIT"
+
+// runPostHook runs the --post-hook command after a commit, with its output
+// passed through.
+func runPostHook(command string) error {
+	hash, err := getLastCommitHash()
+	if err != nil {
+		return err
+	}
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), postHookCommitEnv+"="+hash)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("post-hook %q failed after committing %s: %w", command, hash, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPreHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are sh commands")
+	}
+	tests := []struct {
+		name       string
+		hook       string
+		wantErr    string
+		wantPrompt string
+	}{
+		{
+			name: "passing hook runs before the diff is read",
+			hook: "echo formatted > a.txt && git add a.txt",
+			// The model sees the hook's changes.
+			wantPrompt: "+formatted",
+		},
+		{
+			name:    "failing hook aborts",
+			hook:    "echo lint failed >&2; exit 3",
+			wantErr: `pre-hook "echo lint failed >&2; exit 3" failed`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestRepo(t)
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+
+			client, api := newTestClient(t, "Add a.txt")
+			opts := testRunOptions(client)
+			opts.preHook = tt.hook
+			var err error
+			stderr := capture(t, &os.Stderr, func() { err = run(opts) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("run() = %v, want %q", err, tt.wantErr)
+				}
+				if !strings.Contains(stderr, "lint failed") {
+					t.Errorf("the failing hook's output wasn't shown: %q", stderr)
+				}
+				if n := api.requestCount(); n != 0 {
+					t.Errorf("%d requests were sent after the hook failed", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(api.prompt(0), tt.wantPrompt) {
+				t.Errorf("the prompt doesn't contain %q:\n%s", tt.wantPrompt, api.prompt(0))
+			}
+			if stderr != "" {
+				t.Errorf("a passing hook printed %q without --verbose", stderr)
+			}
+		})
+	}
+}
+
+func TestPostHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are sh commands")
+	}
+	for _, dryRun := range []bool{false, true} {
+		dir := initTestRepo(t)
+		writeTestFile(t, "a.txt", "one\n")
+		testGit(t, "add", ".")
+
+		client, _ := newTestClient(t, "Add a.txt")
+		opts := testRunOptions(client)
+		opts.dryRun = dryRun
+		opts.postHook = `echo "$` + postHookCommitEnv + `" > .git/hook.out`
+		capture(t, &os.Stdout, func() {
+			if err := run(opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		out, err := os.ReadFile(filepath.Join(dir, ".git", "hook.out"))
+		if dryRun {
+			if err == nil {
+				t.Errorf("the post-hook ran in a dry run, writing %q", out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("the post-hook didn't run: %v", err)
+		}
+		if got, want := strings.TrimSpace(string(out)), testGit(t, "rev-parse", "HEAD"); got != want {
+			t.Errorf("$%s = %q, want the new commit %s", postHookCommitEnv, got, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var (
+	// branchIssueRe matches only delimited issue references: a leading
+	// "123-" in a path segment, "issue-123", "gh-123" or "#123". Numbers
+	// such as the year in "release-2024" or the version in "v2-cleanup"
+	// are not issues.
+	branchIssueRe = regexp.MustCompile(`(?:^|/)(\d+)[-_]|(?:^|[/_-])(?i:issue|gh)-(\d+)(?:[/_-]|$)|(?:^|[/_-])#(\d+)(?:[/_-]|$)`)
+	// scpRemoteRe matches scp-like SSH remotes such as git@host:org/repo.git.
+	scpRemoteRe = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+)
+
+// currentBranch returns the checked out branch name, or "" on a detached
+// HEAD. Unlike rev-parse, symbolic-ref also works before the first commit.
+func currentBranch() (string, error) {
+	cmd, cancel := gitCommand("symbolic-ref", "--quiet", "--short", "HEAD")
+	defer cancel()
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// HEAD is not a symbolic ref, i.e. it is detached.
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// issueFromBranch extracts an issue number from a branch name such as
+// "fix/1234-crash", "gh-42" or "feature-#42". It returns "" when there is
+// none.
+func issueFromBranch(branch string) string {
+	m := branchIssueRe.FindStringSubmatch(branch)
+	if m == nil {
+		return ""
+	}
+	return m[1] + m[2] + m[3]
+}
+
+// parseRemoteURL returns the host and "org/repo" path of a git remote URL in
+// HTTPS, ssh:// or scp-like SSH form.
+func parseRemoteURL(remote string) (host string, repoPath string, err error) {
+	remote = strings.TrimSpace(remote)
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", "", fmt.Errorf("parse remote %q: %w", remote, err)
+		}
+		host, repoPath = u.Hostname(), u.Path
+	} else if m := scpRemoteRe.FindStringSubmatch(remote); m != nil {
+		host, repoPath = m[1], m[2]
+	} else {
+		return "", "", fmt.Errorf("unrecognized remote URL %q", remote)
+	}
+
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	if host == "" || repoPath == "" {
+		return "", "", fmt.Errorf("unrecognized remote URL %q", remote)
+	}
+	return host, repoPath, nil
+}
+
+// remoteIssueBaseURL derives the issue tracker URL for the origin remote.
+func remoteIssueBaseURL() (string, error) {
+	remote, err := gitConfig("remote.origin.url")
+	if err != nil {
+		return "", err
+	}
+	host, repoPath, err := parseRemoteURL(remote)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://%s/%s/issues", host, repoPath), nil
+}
+
+// issueTrailer returns the Closes trailer value for the issue referenced by
+// the current branch, or "" if the branch names no issue. Without a base URL,
+// one is derived from origin, falling back to a "#1234" reference.
+func issueTrailer(baseURL string) (string, error) {
+	branch, err := currentBranch()
+	if err != nil {
+		return "", fmt.Errorf("get current branch: %w", err)
+	}
+	issue := issueFromBranch(branch)
+	if issue == "" {
+		return "", nil
+	}
+	if baseURL == "" {
+		baseURL, err = remoteIssueBaseURL()
+		if err != nil {
+			return "#" + issue, nil
+		}
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + issue, nil
+}
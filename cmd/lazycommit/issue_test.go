@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestIssueFromBranch(t *testing.T) {
+	tests := map[string]string{
+		"fix/1234-crash":    "1234",
+		"1234_crash":        "1234",
+		"feature-#42":       "42",
+		"issue-7":           "7",
+		"fix/GH-99-timeout": "99",
+		"gh-12":             "12",
+		"main":              "",
+		"release-2024":      "",
+		"v2-cleanup":        "",
+		"release/2024":      "",
+		"tissue-5":          "",
+	}
+	for branch, want := range tests {
+		if got := issueFromBranch(branch); got != want {
+			t.Errorf("issueFromBranch(%q) = %q, want %q", branch, got, want)
+		}
+	}
+}
+
+func TestParseRemoteURL(t *testing.T) {
+	tests := []struct {
+		remote  string
+		host    string
+		path    string
+		wantErr bool
+	}{
+		{remote: "https://github.com/nguu0123/lazycommit.git", host: "github.com", path: "nguu0123/lazycommit"},
+		{remote: "https://gitlab.example.com:8443/group/sub/repo/", host: "gitlab.example.com", path: "group/sub/repo"},
+		{remote: "ssh://git@github.com:22/nguu0123/lazycommit.git", host: "github.com", path: "nguu0123/lazycommit"},
+		{remote: "git@github.com:nguu0123/lazycommit.git", host: "github.com", path: "nguu0123/lazycommit"},
+		{remote: "git@github.com:nguu0123/lazycommit\n", host: "github.com", path: "nguu0123/lazycommit"},
+		{remote: "/srv/git/repo.git", wantErr: true},
+		{remote: "https://github.com/", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, path, err := parseRemoteURL(tt.remote)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRemoteURL(%q) error = %v, wantErr %v", tt.remote, err, tt.wantErr)
+			continue
+		}
+		if host != tt.host || path != tt.path {
+			t.Errorf("parseRemoteURL(%q) = %q, %q, want %q, %q", tt.remote, host, path, tt.host, tt.path)
+		}
+	}
+}
+
+func TestIssueTrailer(t *testing.T) {
+	initTestRepo(t)
+	testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+	testGit(t, "checkout", "--quiet", "-b", "fix/1234-crash")
+
+	tests := []struct {
+		name    string
+		remote  string
+		baseURL string
+		want    string
+	}{
+		{name: "no remote", want: "#1234"},
+		{name: "from origin", remote: "git@github.com:nguu0123/lazycommit.git", want: "https://github.com/nguu0123/lazycommit/issues/1234"},
+		{name: "explicit base URL", remote: "git@github.com:nguu0123/lazycommit.git", baseURL: "https://jira.example.com/browse/", want: "https://jira.example.com/browse/1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.remote != "" {
+				testGit(t, "remote", "add", "origin", tt.remote)
+				defer testGit(t, "remote", "remove", "origin")
+			}
+			got, err := issueTrailer(tt.baseURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("issueTrailer(%q) = %q, want %q", tt.baseURL, got, tt.want)
+			}
+		})
+	}
+
+	testGit(t, "checkout", "--quiet", "-b", "cleanup")
+	if got, err := issueTrailer(""); err != nil || got != "" {
+		t.Errorf("issueTrailer() on a branch without an issue = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// languageHints are short, language-specific instructions for files with
+// the given extensions.
+var languageHints = []struct {
+	name string
+	exts []string
+	hint string
+}{
+	{"Go", []string{".go"}, "For Go changes, use Go terminology (package, method, interface) and refer to identifiers by their exact names."},
+	{"SQL", []string{".sql"}, "SQL files are usually migrations or schema changes. Say what the migration does to the schema or data."},
+	{"Protocol Buffers", []string{".proto"}, "For .proto changes, mention the affected messages, fields or services and whether the wire format changes compatibly."},
+	{"Markdown", []string{".md", ".markdown"}, "Changes to Markdown files are documentation. Describe them as such."},
+}
+
+// languagePrompt returns hints for the languages of the files in diff, or
+// "" if none are recognized.
+func languagePrompt(diff string) string {
+	exts := map[string]bool{}
+	for _, f := range splitDiff(diff) {
+		exts[strings.ToLower(filepath.Ext(f.path))] = true
+	}
+	var hints []string
+	for _, l := range languageHints {
+		for _, ext := range l.exts {
+			if exts[ext] {
+				hints = append(hints, l.hint)
+				break
+			}
+		}
+	}
+	return strings.Join(hints, "\n")
+}
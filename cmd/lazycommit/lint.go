@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const maxSubjectLength = 72
+
+var conventionalTypes = []string{
+	"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert",
+}
+
+// lintMessage returns the ways msg violates basic commit message rules and,
+// if conventional is set, the Conventional Commits format.
+func lintMessage(msg string, conventional bool) []string {
+	var violations []string
+	subject, rest, _ := strings.Cut(msg, "\n")
+
+	if strings.TrimSpace(subject) == "" {
+		return []string{"subject is empty"}
+	}
+	if n := len([]rune(subject)); n > maxSubjectLength {
+		violations = append(violations, fmt.Sprintf("subject is %d characters, limit is %d", n, maxSubjectLength))
+	}
+	if strings.HasSuffix(subject, ".") {
+		violations = append(violations, "subject ends with a period")
+	}
+	if rest != "" && !strings.HasPrefix(rest, "\n") {
+		violations = append(violations, "subject is not followed by a blank line")
+	}
+
+	if conventional {
+		violations = append(violations, lintConventionalHeader(subject)...)
+	}
+	return violations
+}
+
+func lintConventionalHeader(subject string) []string {
+	m := conventionalHeaderRe.FindStringSubmatch(subject)
+	if m == nil {
+		return []string{"subject does not match <type>(<scope>): <description>"}
+	}
+	var violations []string
+	known := false
+	for _, t := range conventionalTypes {
+		known = known || m[1] == t
+	}
+	if !known {
+		violations = append(violations, fmt.Sprintf("unknown type %q", m[1]))
+	}
+	if scope := strings.Trim(m[2], "()"); m[2] != "" && validateScope(scope) != nil {
+		violations = append(violations, fmt.Sprintf("invalid scope %q", scope))
+	}
+	if strings.TrimSpace(subject[len(m[0]):]) == "" {
+		violations = append(violations, "description is empty")
+	}
+	return violations
+}
+
+// writeLintReport prints the validation result for violations.
+func writeLintReport(w io.Writer, violations []string) {
+	if len(violations) == 0 {
+		fmt.Fprintln(w, "Validation: pass")
+		return
+	}
+	fmt.Fprintln(w, "Validation: fail")
+	for _, v := range violations {
+		fmt.Fprintf(w, "  - %s\n", v)
+	}
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"al.essio.dev/pkg/shellescape"
 	"github.com/coder/pretty"
@@ -19,22 +21,122 @@ import (
 )
 
 var (
-	colorProfile = termenv.ColorProfile()
-	version      = "0.0.1"
+	// colorProfile is used for all styled output. It degrades to no color
+	// when stdout isn't a terminal or NO_COLOR is set.
+	colorProfile = termenv.EnvColorProfile()
+	// wrapCodeBlocks soft-wraps code in the displayed message like prose.
+	wrapCodeBlocks bool
+	version        = "0.0.1"
 )
 
 type runOptions struct {
 	client        *openai.Client
 	openAIBaseURL string
 	model         string
-	dryRun        bool
-	amend         bool
-	ref           string
-	context       []string
+	smallModel    string
+	autoModel     bool
+	autoThreshold int
+	// compareModels, if set, are each used to generate a message to show
+	// instead of committing.
+	compareModels []string
+	tokenBudget   int
+	// modelParams is a JSON object merged into the chat completion request.
+	modelParams string
+	noUsage     bool
+	dryRun      bool
+	allowEmpty  bool
+	onEmpty     string
+	amend       bool
+	// fixup and squash name the commit targeted by a fixup! or squash!
+	// commit.
+	fixup      string
+	squash     string
+	ref        string
+	stash      string
+	diffSource string
+	// diffFile, if set, is read for a pre-computed diff instead of running
+	// git diff.
+	diffFile    string
+	workingTree bool
+	// note attaches the message to ref as a git note.
+	note     bool
+	notesRef string
+	// rewordHead amends only the message of HEAD.
+	rewordHead bool
+	// messageFile, if set, receives the message instead of committing.
+	messageFile      string
+	context          []string
+	contextMode      string
+	contextPosition  string
+	contextFiles     []string
+	contextClipboard bool
+	includeStatus    bool
+	includeExts      []string
+	excludeLockfiles bool
+	diffAlgorithm    string
+	diffFilter       string
+	largeDiffWarn    int
+	maxDiffBytes     int
+	skipWhitespace   bool
+	stripDiffHeaders bool
+	summarizeBinary  bool
+	redact           []string
+	historyDepth     int
+
+	promptFile       string
+	smartPrompt      bool
+	templateFile     string
+	conventional     bool
+	gitmoji          bool
+	voice            string
+	emojiMapFile     string
+	scope            string
+	scopeFromPackage bool
+	breaking         bool
+	noBreaking       bool
+	maxBodyLines     int
+	retryOnEmpty     int
+	strictStream     bool
+	minConfidence    int
+	subjectOnly      bool
+	body             bool
+	clean            bool
+	noNormalize      bool
+	trailingNewline  bool
+	lint             bool
+	strict           bool
+
+	signoff         bool
+	changeID        bool
+	squashTrailer   bool
+	bodyFromCommits bool
+	trailers        []string
+	noVerify        bool
+	preHook         string
+	postHook        string
+	tag             string
+	tagMessage      string
+	issueFromBranch bool
+	prependTicket   bool
+	issueBaseURL    string
+
+	changelog     bool
+	changelogFile string
+
+	verbose          bool
+	verboseDiffLimit int
+	dumpPrompt       bool
+	estimate         bool
+	yes              bool
+	force            bool
+	timing           bool
+	jsonMode         bool
+	jsonOutput       string
 }
 
 func getLastCommitHash() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd, cancel := gitCommand("rev-parse", "HEAD")
+	defer cancel()
 	output, err := cmd.Output()
 	if err != nil {
 		return "", err
@@ -43,7 +145,8 @@ func getLastCommitHash() (string, error) {
 }
 
 func resolveRef(ref string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", ref)
+	cmd, cancel := gitCommand("rev-parse", ref)
+	defer cancel()
 	output, err := cmd.Output()
 	if err != nil {
 		return "", err
@@ -61,103 +164,1204 @@ func formatShellCommand(cmd *exec.Cmd) string {
 	return buf.String()
 }
 
+func pluralCommits(n int) string {
+	if n == 1 {
+		return "1 commit"
+	}
+	return fmt.Sprintf("%d commits", n)
+}
+
+// insertBeforeDiff inserts msg just before the diff, which BuildPrompt
+// always places last.
+func insertBeforeDiff(msgs []openai.ChatCompletionMessage, msg openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	n := len(msgs)
+	return append(msgs[:n-1:n-1], msg, msgs[n-1])
+}
+
+// truncateLines returns s cut to at most n lines, with a marker noting how
+// many lines were dropped. A non-positive n disables truncation.
+func truncateLines(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[:n], "\n") +
+		fmt.Sprintf("\n... (truncated, %d more lines)", len(lines)-n)
+}
+
+// logPrompt writes msgs to w for debugging. The message at diffIndex is
+// truncated to diffLimit lines so large diffs don't flood the terminal.
+func logPrompt(w io.Writer, msgs []openai.ChatCompletionMessage, diffIndex int, diffLimit int) {
+	for i, msg := range msgs {
+		content := msg.Content
+		if i == diffIndex {
+			content = truncateLines(content, diffLimit)
+		}
+		fmt.Fprintf(w, "--- %s ---\n%s\n", msg.Role, content)
+	}
+}
+
+// confirm asks a yes/no question on w and reads the answer from r. Anything
+// but yes, including EOF, is a no.
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// selectModel returns the model to use for a diff of diffTokens tokens. With
+// autoModel set, diffs under the threshold use the cheaper small model.
+func selectModel(opts runOptions, diffTokens int) string {
+	if opts.autoModel && opts.smallModel != "" && diffTokens < opts.autoThreshold {
+		return opts.smallModel
+	}
+	return opts.model
+}
+
+// applyModelDefaults fills in the models not given on the command line
+// from the provider's defaults.
+func applyModelDefaults(opts *runOptions, p provider) {
+	if opts.model == "" {
+		opts.model = p.defaultModel
+	}
+	if opts.smallModel == "" {
+		opts.smallModel = p.defaultSmallModel
+	}
+}
+
+// looksLikeOpenAIKey reports whether key has the shape of an OpenAI API key.
+func looksLikeOpenAIKey(key string) bool {
+	return strings.HasPrefix(key, "sk-") && len(key) >= 20
+}
+
+// explainAPIError marks err as an API failure, replacing authentication
+// failures with a targeted message.
+func explainAPIError(err error) error {
+	var (
+		apiErr *openai.APIError
+		reqErr *openai.RequestError
+	)
+	if (errors.As(err, &apiErr) && apiErr.HTTPStatusCode == 401) ||
+		(errors.As(err, &reqErr) && reqErr.HTTPStatusCode == 401) {
+		return fmt.Errorf("%w: your API key was rejected, check --openai-key or OPENAI_API_KEY: %w", errAPI, err)
+	}
+	return fmt.Errorf("%w: %w", errAPI, err)
+}
+
+// readContextFiles returns the contents of each path, reading "-" from
+// stdin.
+func readContextFiles(paths []string) ([]string, error) {
+	var contexts []string
+	for _, path := range paths {
+		var (
+			b   []byte
+			err error
+		)
+		if path == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read context file %q: %w", path, err)
+		}
+		if s := strings.TrimSpace(string(b)); s != "" {
+			contexts = append(contexts, s)
+		}
+	}
+	return contexts, nil
+}
+
+// readDiffFile returns the diff in path, reading "-" from stdin.
+func readDiffFile(path string) (string, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("read diff file %q: %w", path, err)
+	}
+	return string(b), nil
+}
+
+// rejectsStreamOptions reports whether err looks like an OpenAI-compatible
+// server refusing the stream_options field, which not all servers support.
+func rejectsStreamOptions(err error) bool {
+	var (
+		apiErr *openai.APIError
+		reqErr *openai.RequestError
+		status int
+	)
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+	if status != 400 && status != 422 {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "stream_options") ||
+		strings.Contains(msg, "unknown field") ||
+		strings.Contains(msg, "unrecognized")
+}
+
+// contextPrompt returns the system message introducing user context.
+func contextPrompt(mode string) (string, error) {
+	switch mode {
+	case "must":
+		return "The user has provided additional context that MUST be included in the commit message", nil
+	case "hint":
+		return "The user has provided additional context. Use it as guidance " +
+			"where relevant, but do not repeat it verbatim", nil
+	default:
+		return "", fmt.Errorf("invalid --context-mode %q: must be must or hint", mode)
+	}
+}
+
+// generation is a completed model response.
+type generation struct {
+	text string
+	// The fields below come from the stream and are empty if the provider
+	// doesn't send them.
+	finishReason      openai.FinishReason
+	model             string
+	systemFingerprint string
+	usage             *openai.Usage
+
+	// timeToFirstToken is zero if no content was received.
+	timeToFirstToken time.Duration
+	duration         time.Duration
+}
+
+// generate streams a completion for req to out and returns the result.
+func generate(ctx context.Context, out io.Writer, client *openai.Client, req openai.ChatCompletionRequest) (generation, error) {
+	var gen generation
+	start := time.Now()
+	stream, err := client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return gen, explainAPIError(err)
+	}
+	defer stream.Close()
+
+	msg := &strings.Builder{}
+	color := pretty.FgColor(colorProfile.Color("#2FA8FF"))
+	subjectStyle := pretty.Style{color}
+	if colorProfile != termenv.Ascii {
+		subjectStyle = append(subjectStyle, pretty.Bold())
+	}
+	chunks := newChunkWriter(&sectionWriter{
+		subject: styledWriter{w: out, style: subjectStyle},
+		body:    styledWriter{w: out, style: color},
+	}, 50*time.Millisecond)
+	display := newWrapWriter(chunks, terminalWidth())
+	display.wrapCode = wrapCodeBlocks
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return gen, explainAPIError(err)
+		}
+		if resp.Model != "" {
+			gen.model = resp.Model
+		}
+		if resp.SystemFingerprint != "" {
+			gen.systemFingerprint = resp.SystemFingerprint
+		}
+		if resp.Usage != nil {
+			gen.usage = resp.Usage
+		}
+		if len(resp.Choices) == 0 {
+			break
+		}
+		if fr := resp.Choices[0].FinishReason; fr != "" {
+			gen.finishReason = fr
+		}
+		c := resp.Choices[0].Delta.Content
+		if c != "" && gen.timeToFirstToken == 0 {
+			gen.timeToFirstToken = time.Since(start)
+		}
+
+		msg.WriteString(c)
+		if err := display.WriteString(c); err != nil {
+			return gen, err
+		}
+	}
+	if err := display.Flush(); err != nil {
+		return gen, err
+	}
+	if err := chunks.Flush(); err != nil {
+		return gen, err
+	}
+	fmt.Fprintln(out)
+
+	gen.duration = time.Since(start)
+	gen.text = msg.String()
+	return gen, nil
+}
+
+// logGeneration writes the response metadata to w for debugging.
+func logGeneration(w io.Writer, gen generation) {
+	for _, f := range []struct{ name, value string }{
+		{"finish_reason", string(gen.finishReason)},
+		{"model", gen.model},
+		{"system_fingerprint", gen.systemFingerprint},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(w, "%s: %s\n", f.name, f.value)
+		}
+	}
+}
+
+// trivialChangedLines is the number of changed lines below which a diff
+// gets a subject-only message, unless --body is set.
+const trivialChangedLines = 4
+
+// messageRecord is the structured output of --json and --json-output.
+type messageRecord struct {
+	Message string `json:"message"`
+	Model   string `json:"model"`
+}
+
+func writeMessageRecord(path string, record messageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing JSON output: %w", err)
+	}
+	return nil
+}
+
+// applyDiffSource maps --diff-source onto the options it is shorthand for:
+// staged (the default), working, head or range:<a>..<b>.
+func applyDiffSource(opts *runOptions) error {
+	if opts.diffSource == "" || opts.diffSource == "staged" {
+		return nil
+	}
+	if opts.ref != "" || opts.amend || opts.stash != "" {
+		return fmt.Errorf("--diff-source %s cannot be used with [ref], --amend or stash", opts.diffSource)
+	}
+	switch source := opts.diffSource; {
+	case source == "working":
+		opts.workingTree = true
+	case source == "head":
+		opts.ref = "HEAD"
+	case strings.HasPrefix(source, "range:"):
+		opts.ref = strings.TrimPrefix(source, "range:")
+		if !isRange(opts.ref) {
+			return fmt.Errorf("invalid diff source %q: expected range:<a>..<b>", source)
+		}
+	default:
+		return fmt.Errorf("invalid diff source %q: must be staged, working, head or range:<a>..<b>", source)
+	}
+	return nil
+}
+
+// handleNothingStaged applies --on-empty when the staging area is empty:
+// error leaves it to BuildPrompt to fail, all describes and commits every
+// tracked change, and prompt offers to stage them.
+func handleNothingStaged(opts *runOptions) error {
+	switch opts.onEmpty {
+	case "error", "all", "prompt":
+	default:
+		return fmt.Errorf("invalid --on-empty %q: must be error, all or prompt", opts.onEmpty)
+	}
+	if opts.onEmpty == "error" {
+		return nil
+	}
+	staged, err := hasStagedChanges()
+	if err != nil || staged {
+		return err
+	}
+
+	if opts.onEmpty == "all" {
+		opts.workingTree = true
+		return nil
+	}
+	if !confirm(os.Stdin, os.Stderr, "Nothing is staged. Stage all changes to tracked files?") {
+		return fmt.Errorf("no staged changes, %w", errNoChanges)
+	}
+	cmd, cancel := gitCommand("add", "--update")
+	defer cancel()
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git add --update: %w", err)
+	}
+	return nil
+}
+
+// rewordCommitEnv names the commit for the reword subcommand.
+const rewordCommitEnv = "LAZYCOMMIT_REWORD_COMMIT"
+
+// commitFixup creates a "fixup! <subject>" commit of the staged changes for
+// git rebase --autosquash.
+func commitFixup(opts runOptions) error {
+	cmd := exec.Command(gitBinary, "commit", "--fixup="+opts.fixup)
+	if opts.noVerify {
+		cmd.Args = append(cmd.Args, "--no-verify")
+	}
+	if opts.dryRun {
+		fmt.Println("Run the following command to commit:")
+		fmt.Println(formatShellCommand(cmd))
+		return nil
+	}
+	staged, err := hasStagedChanges()
+	if err != nil {
+		return err
+	}
+	if !staged {
+		return fmt.Errorf("no staged changes, %w", errNoChanges)
+	}
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	if opts.postHook != "" {
+		return runPostHook(opts.postHook)
+	}
+	return nil
+}
+
 func run(opts runOptions) error {
 	workdir, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 
-	if opts.ref != "" && opts.amend {
-		return errors.New("cannot use both [ref] and --amend")
+	if err := applyDiffSource(&opts); err != nil {
+		return err
+	}
+	var diffText string
+	if opts.diffFile != "" {
+		if opts.ref != "" || opts.amend || opts.stash != "" || opts.allowEmpty || opts.workingTree {
+			return errors.New("--diff-file cannot be used with [ref], --amend, --allow-empty, --diff-source or stash")
+		}
+		if opts.includeStatus || opts.summarizeBinary || opts.skipWhitespace || opts.diffAlgorithm != "" || opts.diffFilter != "" {
+			return errors.New("--include-status, --summarize-binary, --skip-whitespace, --diff-algorithm and --diff-filter need git and cannot be used with --diff-file")
+		}
+		b, err := readDiffFile(opts.diffFile)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(b) == "" {
+			return fmt.Errorf("%w: diff file %q is empty", errNoChanges, opts.diffFile)
+		}
+		diffText = b
+	}
+	if opts.bodyFromCommits {
+		if !isRange(opts.ref) {
+			return errors.New("--body-from-commits requires a base..head range")
+		}
+		// The model writes the subject; the body lists the commits.
+		opts.subjectOnly = true
+	}
+
+	if opts.ref != "" && opts.amend {
+		return errors.New("cannot use both [ref] and --amend")
+	}
+
+	if opts.stash != "" && (opts.amend || opts.ref != "") {
+		return errors.New("cannot describe a stash with [ref] or --amend")
+	}
+
+	if opts.allowEmpty {
+		if opts.ref != "" || opts.stash != "" {
+			return errors.New("--allow-empty cannot be used with [ref] or stash")
+		}
+		staged, err := hasStagedChanges()
+		if err != nil {
+			return err
+		}
+		if !staged && !opts.amend && len(opts.context) == 0 && len(opts.contextFiles) == 0 {
+			return fmt.Errorf("%w: --allow-empty with nothing staged needs --context or --context-file to describe the commit", errNoChanges)
+		}
+	}
+
+	if opts.diffAlgorithm != "" {
+		if err := validateDiffAlgorithm(opts.diffAlgorithm); err != nil {
+			return err
+		}
+	}
+	if err := validateDiffFilter(opts.diffFilter); err != nil {
+		return err
+	}
+	if (opts.breaking || opts.noBreaking) && !opts.conventional {
+		return errors.New("--breaking and --no-breaking require --conventional")
+	}
+	if opts.breaking && opts.noBreaking {
+		return errors.New("cannot use both --breaking and --no-breaking")
+	}
+	if opts.scopeFromPackage && !opts.conventional {
+		return errors.New("--scope-from-package requires --conventional")
+	}
+	if opts.scope != "" {
+		if !opts.conventional {
+			return errors.New("--scope requires --conventional")
+		}
+		if err := validateScope(opts.scope); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range opts.trailers {
+		if _, _, err := parseTrailer(t); err != nil {
+			return err
+		}
+	}
+
+	if opts.fixup != "" || opts.squash != "" {
+		if opts.fixup != "" && opts.squash != "" {
+			return errors.New("cannot use both --fixup and --squash")
+		}
+		if opts.ref != "" || opts.amend || opts.stash != "" || opts.note || opts.messageFile != "" || opts.diffFile != "" || opts.tag != "" {
+			return errors.New("--fixup and --squash cannot be used with [ref], --amend, --diff-file, --tag or stash")
+		}
+		target := opts.fixup + opts.squash
+		if _, err := resolveRef(target + "^{commit}"); err != nil {
+			return fmt.Errorf("resolve %q: %w", target, err)
+		}
+	}
+	if opts.fixup != "" {
+		// Autosquash discards a fixup's message, so there is nothing to
+		// generate.
+		return commitFixup(opts)
+	}
+
+	if opts.tagMessage != "" && opts.tag == "" {
+		return errors.New("--tag-message requires --tag")
+	}
+	if opts.tag != "" {
+		if opts.stash != "" || opts.note || opts.messageFile != "" || opts.diffFile != "" || opts.changelog {
+			return errors.New("--tag can only be used when committing")
+		}
+		if err := validateNewTag(opts.tag); err != nil {
+			return err
+		}
+	}
+
+	redactions, err := compileRedactPatterns(opts.redact)
+	if err != nil {
+		return err
+	}
+
+	if opts.ref == "" && !opts.amend && opts.stash == "" && !opts.allowEmpty && !opts.workingTree && opts.diffFile == "" {
+		if err := handleNothingStaged(&opts); err != nil {
+			return err
+		}
+	}
+
+	// Find out now whether there is anything for git commit to take, so
+	// describing a [ref] with nothing staged fails before calling the API.
+	commits := !opts.dryRun && !opts.dumpPrompt && !opts.jsonMode && !opts.changelog &&
+		!opts.note && opts.stash == "" && opts.messageFile == "" && opts.diffFile == "" && len(opts.compareModels) == 0
+	var stagedBefore bool
+	if commits && !opts.amend && !opts.rewordHead && !opts.allowEmpty && !opts.workingTree {
+		if stagedBefore, err = hasStagedChanges(); err != nil {
+			return err
+		}
+		if !stagedBefore && opts.ref != "" {
+			return fmt.Errorf("%w: nothing is staged to commit with the message for %s", errNoChanges, opts.ref)
+		}
+	}
+
+	// Rewriting a pushed commit forces everyone else to recover from it.
+	// Only ask when HEAD will actually be rewritten.
+	if commits && (opts.amend || opts.rewordHead) && !opts.yes && !opts.force {
+		remotes, err := remoteBranchesContaining("HEAD")
+		if err != nil {
+			return err
+		}
+		if len(remotes) > 0 {
+			question := fmt.Sprintf("HEAD has been pushed to %s. Amend it anyway?", strings.Join(remotes, ", "))
+			if !confirm(os.Stdin, os.Stderr, question) {
+				return errors.New("aborted")
+			}
+		}
+	}
+
+	if opts.preHook != "" {
+		if err := runPreHook(os.Stderr, opts.preHook, opts.verbose); err != nil {
+			return err
+		}
+	}
+
+	var hash string
+	if opts.amend {
+		hash, err = getLastCommitHash()
+		if err != nil {
+			return err
+		}
+	} else if isRange(opts.ref) {
+		hash, err = resolveRange(opts.ref)
+		if err != nil {
+			return fmt.Errorf("resolve range %q: %w", opts.ref, err)
+		}
+	} else if opts.ref != "" {
+		hash, err = resolveRef(opts.ref)
+		if err != nil {
+			return fmt.Errorf("resolve ref %q: %w", opts.ref, err)
+		}
+	}
+
+	// Keep stdout clean for JSON output.
+	var log io.Writer = os.Stdout
+	if opts.dumpPrompt || opts.jsonMode {
+		log = os.Stderr
+	}
+	models := []*string{&opts.model, &opts.smallModel}
+	for i := range opts.compareModels {
+		models = append(models, &opts.compareModels[i])
+	}
+	for _, model := range models {
+		if resolved := resolveModelAlias(*model); resolved != *model {
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "model alias %s resolves to %s\n", *model, resolved)
+			}
+			*model = resolved
+		}
+	}
+
+	budget := opts.tokenBudget
+	if budget <= 0 {
+		var known bool
+		budget, known = contextWindow(opts.model)
+		if !known {
+			fmt.Fprintf(os.Stderr, "warning: unknown context window for model %s, assuming %d tokens (set --token-budget to override)\n", opts.model, budget)
+		}
+	}
+	msgs, err := BuildPrompt(log, promptOptions{
+		dir:              workdir,
+		commitHash:       hash,
+		amend:            opts.amend,
+		maxTokens:        budget,
+		includeStatus:    opts.includeStatus,
+		historyDepth:     opts.historyDepth,
+		includeExts:      opts.includeExts,
+		diffAlgorithm:    opts.diffAlgorithm,
+		allowEmpty:       opts.allowEmpty,
+		largeDiffWarn:    opts.largeDiffWarn,
+		skipWhitespace:   opts.skipWhitespace,
+		summarizeBinary:  opts.summarizeBinary,
+		workingTree:      opts.workingTree,
+		excludeLockfiles: opts.excludeLockfiles,
+		promptFile:       opts.promptFile,
+		stash:            opts.stash,
+		diffText:         diffText,
+		diffFilter:       opts.diffFilter,
+		maxDiffBytes:     opts.maxDiffBytes,
+	})
+	if err != nil {
+		return err
+	}
+
+	var squashed int
+	if isRange(hash) {
+		squashed, err = commitCount(hash)
+		if err != nil {
+			return err
+		}
+		msgs = insertBeforeDiff(msgs, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleSystem,
+			Content: fmt.Sprintf("The diff combines %s that will be squashed "+
+				"into one. Describe their overall change.", pluralCommits(squashed)),
+		})
+	}
+
+	fileContexts, err := readContextFiles(opts.contextFiles)
+	if err != nil {
+		return err
+	}
+	opts.context = append(opts.context, fileContexts...)
+	if opts.contextClipboard {
+		clip, err := readClipboard()
+		if err != nil {
+			return err
+		}
+		if clip == "" {
+			fmt.Fprintln(os.Stderr, "warning: the clipboard is empty")
+		} else {
+			opts.context = append(opts.context, clip)
+		}
+	}
+
+	// BuildPrompt always ends with the diff.
+	diffIndex := len(msgs) - 1
+
+	// An explicit --scope overrides the derived one.
+	if opts.scopeFromPackage && opts.scope == "" {
+		opts.scope = packageScope(msgs[diffIndex].Content)
+		if opts.verbose && opts.scope != "" {
+			fmt.Fprintf(os.Stderr, "using scope %s\n", opts.scope)
+		}
+	}
+
+	if opts.conventional {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: conventionalPrompt(opts.scope),
+		})
+		switch {
+		case opts.breaking:
+			msgs = append(msgs, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "This is a breaking change. Mark it with ! and a BREAKING CHANGE footer.",
+			})
+		case opts.noBreaking:
+			msgs = append(msgs, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "This is not a breaking change. Don't use ! or a BREAKING CHANGE footer.",
+			})
+		}
+	}
+
+	switch opts.contextPosition {
+	case "before", "after":
+	default:
+		return fmt.Errorf("invalid --context-position %q: must be before or after", opts.contextPosition)
+	}
+	if len(opts.context) > 0 {
+		prompt, err := contextPrompt(opts.contextMode)
+		if err != nil {
+			return err
+		}
+		contextMsgs := []openai.ChatCompletionMessage{{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt,
+		}}
+		for _, context := range opts.context {
+			contextMsgs = append(contextMsgs, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: context,
+			})
+		}
+		if opts.contextPosition == "before" {
+			msgs = append(msgs[:diffIndex:diffIndex], append(contextMsgs, msgs[diffIndex:]...)...)
+			diffIndex += len(contextMsgs)
+		} else {
+			msgs = append(msgs, contextMsgs...)
+		}
+	}
+
+	if opts.subjectOnly {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "Output only a single subject line. Never include a body, regardless of the size of the diff.",
+		})
+	}
+	if opts.note {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleSystem,
+			Content: "Instead of a commit message, write a git note for this commit: " +
+				"a plain-text description of what changed and why, for readers " +
+				"who want more detail than the commit message gives.",
+		})
+	}
+
+	if opts.rewordHead {
+		current, err := gitOutput("log", "-1", "--format=%B", hash)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleSystem,
+			Content: "The commit's current message is below. Write an improved " +
+				"message for the same change, keeping any details that the " +
+				"diff doesn't explain.\n\n" + current,
+		})
+	}
+
+	if voice, err := voicePrompt(opts.voice); err != nil {
+		return err
+	} else if voice != "" {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: voice,
+		})
+	}
+
+	if opts.gitmoji || opts.emojiMapFile != "" {
+		emoji := defaultGitmoji
+		if opts.emojiMapFile != "" {
+			if emoji, err = loadEmojiMap(opts.emojiMapFile); err != nil {
+				return err
+			}
+		}
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: gitmojiPrompt(emoji),
+		})
+	}
+
+	if opts.smartPrompt {
+		if hints := languagePrompt(msgs[diffIndex].Content); hints != "" {
+			msgs = append(msgs, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: hints,
+			})
+		}
+	}
+
+	snippets, err := readPromptSnippets()
+	if err != nil {
+		return err
+	}
+	if prompt := snippetPrompt(snippets, msgs[diffIndex].Content); prompt != "" {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt,
+		})
+	}
+
+	// Trivial changes rarely need explaining, so ask for just a subject.
+	// Templates, notes, changelogs and empty commits have their own shape.
+	trivialApplies := !opts.subjectOnly && !opts.body && opts.templateFile == "" &&
+		!opts.note && !opts.changelog && !opts.allowEmpty
+	if trivialApplies && changedLines(msgs[diffIndex].Content) < trivialChangedLines {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "This change is trivial. Output only a subject line, without a body.",
+		})
 	}
 
-	var hash string
-	if opts.amend {
-		hash, err = getLastCommitHash()
+	if opts.templateFile != "" {
+		template, err := loadCommitTemplate(opts.templateFile)
 		if err != nil {
 			return err
 		}
-	} else if opts.ref != "" {
-		hash, err = resolveRef(opts.ref)
-		if err != nil {
-			return fmt.Errorf("resolve ref %q: %w", opts.ref, err)
-		}
-	}
-
-	msgs, err := BuildPrompt(os.Stdout, workdir, hash, opts.amend, 128000)
-	if err != nil {
-		return err
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: commitTemplatePrompt(template),
+		})
 	}
 
-	if len(opts.context) > 0 {
+	if opts.changelog {
 		msgs = append(msgs, openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: "The user has provided additional context that MUST be included in the commit message",
+			Content: changelogPrompt,
 		})
-		for _, context := range opts.context {
-			msgs = append(msgs, openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleUser,
-				Content: context,
-			})
+	}
+
+	redactMessages(msgs, redactions)
+
+	if opts.stripDiffHeaders {
+		before := CountTokens(msgs[diffIndex])
+		msgs[diffIndex].Content = stripDiffHeaders(msgs[diffIndex].Content)
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "stripping diff headers saved %d tokens\n", before-CountTokens(msgs[diffIndex]))
+		}
+	}
+
+	model := selectModel(opts, CountTokens(msgs[diffIndex]))
+
+	if opts.dumpPrompt {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(msgs)
+	}
+
+	if opts.estimate {
+		tokens := CountTokens(msgs...)
+		if cost, ok := promptCost(model, tokens); ok {
+			fmt.Fprintf(os.Stderr, "estimated cost with %s: %d prompt tokens, $%.4f +output\n", model, tokens, cost)
+		} else {
+			fmt.Fprintf(os.Stderr, "estimated cost with %s: %d prompt tokens, unknown pricing\n", model, tokens)
+		}
+		if !opts.yes && !confirm(os.Stdin, os.Stderr, "Continue?") {
+			return errors.New("aborted")
 		}
 	}
 
+	if opts.verbose {
+		fmt.Fprintf(os.Stderr, "using model %s\n", model)
+		logPrompt(os.Stderr, msgs, diffIndex, opts.verboseDiffLimit)
+	}
+
 	ctx := context.Background()
-	stream, err := opts.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
-		Model:       opts.model,
+	req := openai.ChatCompletionRequest{
+		Model:       model,
 		Stream:      true,
 		Temperature: 0,
-		StreamOptions: &openai.StreamOptions{
+		Messages:    msgs,
+	}
+	if !opts.noUsage {
+		req.StreamOptions = &openai.StreamOptions{
 			IncludeUsage: true,
-		},
-		Messages: msgs,
-	})
+		}
+	}
+	if opts.modelParams != "" {
+		unknown, err := applyModelParams(&req, opts.modelParams)
+		if err != nil {
+			return err
+		}
+		if len(unknown) > 0 {
+			fmt.Fprintf(os.Stderr, "warning: ignoring unknown --model-params fields: %s\n", strings.Join(unknown, ", "))
+		}
+	}
+
+	if len(opts.compareModels) > 0 {
+		return compareModels(ctx, os.Stdout, opts.client, req, opts.compareModels)
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.jsonMode {
+		out = io.Discard
+	}
+	gen, err := generate(ctx, out, opts.client, req)
+	if err != nil && req.StreamOptions != nil && rejectsStreamOptions(err) {
+		fmt.Fprintln(os.Stderr, "warning: server rejected stream_options, retrying without usage (use --no-usage to skip this)")
+		req.StreamOptions = nil
+		gen, err = generate(ctx, out, opts.client, req)
+	}
+	// Sampling a little more randomly usually gets past an empty reply.
+	for i := 0; err == nil && strings.TrimSpace(gen.text) == "" && i < opts.retryOnEmpty; i++ {
+		req.Temperature += 0.2
+		fmt.Fprintf(os.Stderr, "warning: the model returned an empty message, retrying at temperature %.1f\n", req.Temperature)
+		gen, err = generate(ctx, out, opts.client, req)
+	}
+	if err != nil {
+		return err
+	}
+	// Not trimmed: a first reply that is too long is condensed instead.
+	// Cleaning first keeps preambles and code fences from counting as body
+	// lines.
+	generated, err := finishReply(gen.text, opts.clean, 0)
 	if err != nil {
 		return err
 	}
-	defer stream.Close()
 
-	msg := &bytes.Buffer{}
-	color := pretty.FgColor(colorProfile.Color("#2FA8FF"))
+	if opts.maxBodyLines > 0 && countBodyLines(generated) > opts.maxBodyLines {
+		req.Messages = append(req.Messages,
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: generated,
+			},
+			openai.ChatCompletionMessage{
+				Role: openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("The body is too long. Condense it to at most %d lines, "+
+					"keeping the most important points first.", opts.maxBodyLines),
+			},
+		)
+		gen, err = generate(ctx, out, opts.client, req)
+		if err != nil {
+			return err
+		}
+		if generated, err = finishReply(gen.text, opts.clean, opts.maxBodyLines); err != nil {
+			return err
+		}
+	}
 
-	for {
-		resp, err := stream.Recv()
+	if opts.minConfidence > 0 {
+		diff := msgs[diffIndex].Content
+		confidence, err := rateConfidence(ctx, opts.client, model, diff, generated)
 		if err != nil {
-			if err == io.EOF {
-				break
+			return err
+		}
+		if confidence < opts.minConfidence {
+			fmt.Fprintf(os.Stderr, "warning: confidence %d is below %d, regenerating\n", confidence, opts.minConfidence)
+			req.Messages = append(req.Messages,
+				openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: generated,
+				},
+				openai.ChatCompletionMessage{
+					Role: openai.ChatMessageRoleUser,
+					Content: "That message may not accurately describe the diff. Read the " +
+						"diff again carefully and write a message that describes only " +
+						"what it actually changes.",
+				},
+			)
+			if gen, err = generate(ctx, out, opts.client, req); err != nil {
+				return err
+			}
+			if generated, err = finishReply(gen.text, opts.clean, opts.maxBodyLines); err != nil {
+				return err
 			}
+			if confidence, err = rateConfidence(ctx, opts.client, model, diff, generated); err != nil {
+				return err
+			}
+			if confidence < opts.minConfidence {
+				return fmt.Errorf("%w: confidence %d is below --min-confidence %d", errLint, confidence, opts.minConfidence)
+			}
+		}
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "confidence: %d\n", confidence)
+		}
+	}
+
+	if opts.strictStream && gen.finishReason != openai.FinishReasonStop {
+		reason := string(gen.finishReason)
+		if reason == "" {
+			reason = "none"
+		}
+		return fmt.Errorf("%w: the response stream ended without finishing (finish_reason: %s)", errAPI, reason)
+	}
+	if opts.dryRun && opts.verbose {
+		logGeneration(os.Stderr, gen)
+	}
+	if opts.timing {
+		fmt.Fprintf(os.Stderr, "time to first token: %s, total: %s\n",
+			gen.timeToFirstToken.Round(time.Millisecond), gen.duration.Round(time.Millisecond))
+	}
+	if opts.changelog {
+		if opts.changelogFile != "" {
+			return prependChangelogFile(opts.changelogFile, changelogEntry(generated))
+		}
+		return nil
+	}
+
+	commitMsg := generated
+	if opts.templateFile != "" {
+		commitMsg = stripCommentLines(commitMsg)
+	}
+	if opts.subjectOnly {
+		commitMsg, _, _ = strings.Cut(strings.TrimSpace(commitMsg), "\n")
+	}
+	if opts.bodyFromCommits {
+		subjects, err := commitSubjects(hash)
+		if err != nil {
 			return err
 		}
-		if len(resp.Choices) == 0 {
-			break
+		commitMsg += "\n\n- " + strings.Join(subjects, "\n- ")
+	}
+	if opts.scope != "" {
+		commitMsg = forceScope(commitMsg, opts.scope)
+	}
+	if opts.conventional {
+		switch {
+		case opts.noBreaking:
+			commitMsg = unmarkBreaking(commitMsg)
+		case opts.breaking || isBreaking(commitMsg):
+			commitMsg = markBreaking(commitMsg)
 		}
-		c := resp.Choices[0].Delta.Content
+	}
+	if opts.prependTicket {
+		var prefix, id string
+		var err error
+		if opts.messageFile != "" {
+			prefix, id, err = readTicketPrefix(opts.messageFile)
+		} else {
+			prefix, id, err = pendingTicketPrefix()
+		}
+		if err != nil {
+			return err
+		}
+		if prefix != "" {
+			commitMsg = prependTicket(commitMsg, prefix, id)
+		}
+	}
+	if opts.issueFromBranch {
+		issue, err := issueTrailer(opts.issueBaseURL)
+		if err != nil {
+			return err
+		}
+		if issue != "" {
+			commitMsg = appendTrailer(commitMsg, "Closes", issue)
+		}
+	}
+	if opts.squashTrailer && squashed > 0 {
+		commitMsg = appendTrailer(commitMsg, "Squashes", pluralCommits(squashed))
+	}
+	for _, t := range opts.trailers {
+		key, value, _ := parseTrailer(t)
+		commitMsg = appendTrailer(commitMsg, key, value)
+	}
+	if opts.signoff {
+		signoff, err := signoffTrailer()
+		if err != nil {
+			return fmt.Errorf("sign off: %w", err)
+		}
+		commitMsg = appendTrailer(commitMsg, "Signed-off-by", signoff)
+	}
+	if opts.changeID && !changeIDRe.MatchString(commitMsg) {
+		// Amending, rewording HEAD and rewording in a rebase all replace
+		// the commit named by hash.
+		var replacing string
+		if opts.amend || opts.rewordHead || opts.messageFile != "" {
+			replacing = hash
+		}
+		id, err := changeIDTrailer(commitMsg, replacing)
+		if err != nil {
+			return fmt.Errorf("change id: %w", err)
+		}
+		commitMsg = appendTrailer(commitMsg, "Change-Id", id)
+	}
 
-		msg.WriteString(c)
-		pretty.Fprintf(os.Stdout, color, "%s", c)
+	if !opts.noNormalize {
+		commitMsg = normalizeWhitespace(commitMsg)
+	}
+
+	if opts.lint || opts.strict || (opts.dryRun && opts.conventional) {
+		violations := lintMessage(commitMsg, opts.conventional)
+		if opts.dryRun || len(violations) > 0 {
+			writeLintReport(os.Stderr, violations)
+		}
+		if opts.strict && len(violations) > 0 {
+			return fmt.Errorf("%w: %s", errLint, strings.Join(violations, "; "))
+		}
+	}
+
+	record := messageRecord{Message: commitMsg, Model: model}
+	if opts.jsonOutput != "" {
+		if err := writeMessageRecord(opts.jsonOutput, record); err != nil {
+			return err
+		}
+	}
+	if opts.jsonMode {
+		return json.NewEncoder(os.Stdout).Encode(record)
+	}
+
+	if opts.messageFile != "" {
+		content := commitMsg
+		if opts.trailingNewline {
+			content += "\n"
+		}
+		// Git commits the file's contents once the editor exits.
+		if err := os.WriteFile(opts.messageFile, []byte(content), 0o644); err != nil {
+			return fmt.Errorf("writing message file: %w", err)
+		}
+		return nil
+	}
+
+	if opts.stash != "" || opts.diffFile != "" {
+		// The message was already streamed; stashes and diff files aren't
+		// committed.
+		return nil
+	}
+
+	if opts.note {
+		noteCmd := exec.Command(gitBinary, "notes")
+		if opts.notesRef != "" {
+			noteCmd.Args = append(noteCmd.Args, "--ref", opts.notesRef)
+		}
+		noteCmd.Args = append(noteCmd.Args, "add", "-m", commitMsg, hash)
+		if opts.dryRun {
+			fmt.Println("Run the following command to add the note:")
+			fmt.Println(formatShellCommand(noteCmd))
+			return nil
+		}
+		noteCmd.Stderr = os.Stderr
+		noteCmd.Stdout = os.Stdout
+		return noteCmd.Run()
 	}
-	fmt.Println()
 
-	cmd := exec.Command("git", "commit", "-m", msg.String())
+	cmd := exec.Command(gitBinary, "commit", "-m", commitMsg)
+	if !opts.trailingNewline {
+		// git commit -m always ends the message with a newline.
+		cmd = exec.Command(gitBinary, "commit", "--cleanup=verbatim", "--file=-")
+		cmd.Stdin = strings.NewReader(commitMsg)
+	}
 	if opts.amend {
 		cmd.Args = append(cmd.Args, "--amend")
 	}
+	if opts.rewordHead {
+		// --only without paths commits none of the staged changes.
+		cmd.Args = append(cmd.Args, "--amend", "--only")
+	}
+	if opts.noVerify {
+		cmd.Args = append(cmd.Args, "--no-verify")
+	}
+	if opts.allowEmpty {
+		cmd.Args = append(cmd.Args, "--allow-empty")
+	}
+	if opts.workingTree {
+		cmd.Args = append(cmd.Args, "--all")
+	}
+	if opts.squash != "" {
+		// git puts "squash! <subject>" above the message.
+		cmd.Args = append(cmd.Args, "--squash="+opts.squash)
+	}
+
+	if opts.dryRun && opts.amend {
+		current, err := gitOutput("log", "-1", "--format=%B")
+		if err != nil {
+			return err
+		}
+		fmt.Print(messageDiff(current, commitMsg))
+	}
+	var tagCmd *exec.Cmd
+	if opts.tag != "" {
+		tagCmd = exec.Command(gitBinary, "tag")
+		if opts.tagMessage != "" {
+			tagCmd.Args = append(tagCmd.Args, "--annotate", "--message", opts.tagMessage)
+		}
+		tagCmd.Args = append(tagCmd.Args, opts.tag)
+	}
 
 	if opts.dryRun {
 		fmt.Println("Run the following command to commit:")
+		if cmd.Stdin != nil {
+			fmt.Printf("printf %%s %s | ", shellescape.Quote(commitMsg))
+		}
 		fmt.Println(formatShellCommand(cmd))
+		if tagCmd != nil {
+			fmt.Println("Then tag it with:")
+			fmt.Println(formatShellCommand(tagCmd))
+		}
 		return nil
 	}
 
+	// Hooks or the user may have unstaged everything while we were
+	// generating. Catch that here rather than letting git fail.
+	if stagedBefore {
+		staged, err := hasStagedChanges()
+		if err != nil {
+			return err
+		}
+		if !staged {
+			return fmt.Errorf("%w: the staging area became empty after generating the message", errNoChanges)
+		}
+	}
+
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-	return cmd.Run()
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	if tagCmd != nil {
+		tagCmd.Stderr = os.Stderr
+		if err := tagCmd.Run(); err != nil {
+			return fmt.Errorf("tagging the commit as %s: %w", opts.tag, err)
+		}
+	}
+	if opts.postHook != "" {
+		return runPostHook(opts.postHook)
+	}
+	return nil
 }
 
 func main() {
 	var opts runOptions
 	var openAIKey string
+	var maxRetries int
+	var providerName string
+	var opItem string
+	var openAIProject string
+	var gitPath string
+	var noColor bool
 
 	CompletionCmd := &cobra.Command{
 		Use:       "completion [SHELL]",
@@ -183,42 +1387,364 @@ func main() {
 			return nil
 		},
 	}
+	// findAPIKey returns the key from --openai-key, --op-item or the
+	// provider's environment variable, in that order. It is empty if none
+	// is set.
+	findAPIKey := func(p provider) (string, error) {
+		if openAIKey != "" {
+			return openAIKey, nil
+		}
+		if opItem != "" {
+			return readOpSecret(opItem)
+		}
+		if p.keyEnv != "" {
+			return os.Getenv(p.keyEnv), nil
+		}
+		return "", nil
+	}
+	newClient := func(p provider, key string) (*openai.Client, error) {
+		if openAIProject == "" {
+			openAIProject = os.Getenv("OPENAI_PROJECT")
+		}
+		client, err := p.newClient(providerConfig{
+			apiKey:     key,
+			baseURL:    opts.openAIBaseURL,
+			project:    openAIProject,
+			httpClient: newRetryClient(maxRetries),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating %s client: %w", p.name, err)
+		}
+		return client, nil
+	}
+
+	// prepare finishes option setup shared by the commands that generate
+	// messages.
+	prepare := func(cmd *cobra.Command) error {
+		// Errors past this point are reported by main, not cobra.
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+
+		if noColor {
+			colorProfile = termenv.Ascii
+		}
+		if err := setGitBinary(gitPath); err != nil {
+			return err
+		}
+		if opts.changelogFile != "" {
+			opts.changelog = true
+		}
+
+		p, err := lookupProvider(providerName)
+		if err != nil {
+			return err
+		}
+		applyModelDefaults(&opts, p)
+
+		if opts.dumpPrompt || opts.fixup != "" {
+			// The API isn't called, so no key is needed.
+			return nil
+		}
+		key, err := findAPIKey(p)
+		if err != nil {
+			return err
+		}
+		if key == "" && p.keyEnv != "" {
+			return fmt.Errorf("%w: set %s or pass --openai-key", errMissingAPIKey, p.keyEnv)
+		}
+		if p.validKey != nil && !p.validKey(key) {
+			fmt.Fprintf(os.Stderr, "warning: API key does not look like a valid %s key\n", p.name)
+		}
+		opts.client, err = newClient(p, key)
+		return err
+	}
+
 	rootCmd := &cobra.Command{
-		Use:   "lazycommit [ref]",
+		Use:   "lazycommit [ref | base..head]",
 		Short: "Commit message generator using LLM",
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepare(cmd); err != nil {
+				return err
+			}
 			if len(args) > 0 {
 				opts.ref = args[0]
 			}
+			return run(opts)
+		},
+	}
 
-			if openAIKey == "" {
-				openAIKey = os.Getenv("OPENAI_API_KEY")
-				if openAIKey == "" {
-					fmt.Fprintln(os.Stderr, "OPENAI_API_KEY is not set")
-					os.Exit(1)
-				}
+	stashCmd := &cobra.Command{
+		Use:   "stash [stash@{n}]",
+		Short: "Describe a stash without committing",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepare(cmd); err != nil {
+				return err
+			}
+			opts.stash = "stash@{0}"
+			if len(args) > 0 {
+				opts.stash = args[0]
+			}
+			return run(opts)
+		},
+	}
+
+	var aliasName string
+	var aliasGlobal, aliasDryRun bool
+	initAliasCmd := &cobra.Command{
+		Use:   "init-alias [-- lazycommit flags...]",
+		Short: "Add a git alias that runs lazycommit",
+		Long: "Add a git alias (\"git lc\" by default) that runs lazycommit with the\n" +
+			"given flags. The alias is written to the repository's config unless\n" +
+			"--global is set.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			if err := setGitBinary(""); err != nil {
+				return err
+			}
+			gitCmd, err := aliasCommand(aliasName, aliasGlobal, args)
+			if err != nil {
+				return err
+			}
+			if aliasDryRun {
+				fmt.Println(formatShellCommand(gitCmd))
+				return nil
+			}
+			gitCmd.Stderr = os.Stderr
+			if err := gitCmd.Run(); err != nil {
+				return fmt.Errorf("writing alias: %w", err)
+			}
+			fmt.Printf("Added alias: git %s\n", aliasName)
+			return nil
+		},
+	}
+	initAliasCmd.Flags().StringVar(&aliasName, "name", "lc", "The alias name")
+	initAliasCmd.Flags().BoolVar(&aliasGlobal, "global", false, "Write the alias to the global git config")
+	initAliasCmd.Flags().BoolVarP(&aliasDryRun, "dry-run", "d", false, "Print the git config command instead of running it")
+
+	noteCmd := &cobra.Command{
+		Use:   "note [ref]",
+		Short: "Attach a generated git note describing a commit",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepare(cmd); err != nil {
+				return err
+			}
+			opts.note = true
+			opts.ref = "HEAD"
+			if len(args) > 0 {
+				opts.ref = args[0]
+			}
+			return run(opts)
+		},
+	}
+	noteCmd.Flags().StringVar(&opts.notesRef, "notes-ref", "", "The notes ref to add the note to (default: git's, usually refs/notes/commits)")
+
+	rewordCmd := &cobra.Command{
+		Use:   "reword [file]",
+		Short: "Regenerate the message of the last commit, or of one being reworded in a rebase",
+		Long: "Without arguments, amend only the message of the last commit, based on\n" +
+			"its diff and current message. Staged changes are left alone.\n\n" +
+			"With a file, write a new message for a commit being reworded into it.\n" +
+			"This is meant to be git's editor during an interactive rebase:\n\n" +
+			"  GIT_SEQUENCE_EDITOR=\"$EDITOR\" GIT_EDITOR=\"lazycommit reword\" git rebase -i\n\n" +
+			"The commit is read from $" + rewordCommitEnv + ", defaulting to HEAD,\n" +
+			"which is the commit being reworded while git waits for the editor.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 && filepath.Base(args[0]) == "git-rebase-todo" {
+				cmd.SilenceUsage = true
+				return errors.New("reword can't edit the rebase todo list, set GIT_SEQUENCE_EDITOR to your usual editor")
 			}
-			client := openai.NewClient(openAIKey)
-			opts.client = client
+			if err := prepare(cmd); err != nil {
+				return err
+			}
+			opts.ref = "HEAD"
+			if len(args) == 0 {
+				opts.rewordHead = true
+				return run(opts)
+			}
+			if commit := os.Getenv(rewordCommitEnv); commit != "" {
+				opts.ref = commit
+			}
+			opts.messageFile = args[0]
+			return run(opts)
+		},
+	}
 
+	compareCmd := &cobra.Command{
+		Use:   "compare",
+		Short: "Generate a message with each of several models and show them side by side",
+		Long: "Generate a message for the staged changes with each of --models and\n" +
+			"print them side by side with their latency and token usage. Nothing\n" +
+			"is committed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepare(cmd); err != nil {
+				return err
+			}
+			if len(opts.compareModels) < 2 {
+				return errors.New("compare needs at least two --models")
+			}
 			return run(opts)
 		},
 	}
+	compareCmd.Flags().StringSliceVar(&opts.compareModels, "models", nil, "The models to compare, e.g. gpt-4o,gpt-4o-mini")
+
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that git, the API key and the API endpoint are set up",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			if noColor {
+				colorProfile = termenv.Ascii
+			}
+			p, err := lookupProvider(providerName)
+			if err != nil {
+				return err
+			}
+			key, keyErr := findAPIKey(p)
+			var client *openai.Client
+			if keyErr == nil && (key != "" || p.keyEnv == "") {
+				if client, err = newClient(p, key); err != nil {
+					return err
+				}
+			}
+			return runDoctor(os.Stdout, doctorChecks(gitPath, p, key, keyErr, client))
+		},
+	}
+
+	var groupBy string
+	var groupDepth int
+	batchCmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Commit unstaged changes in groups, generating a message for each",
+		Long: "Group the unstaged changes, including untracked files, and for each\n" +
+			"group stage it, generate a message and commit. Nothing may be staged\n" +
+			"beforehand. With --dry-run, print each group's commit command instead.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepare(cmd); err != nil {
+				return err
+			}
+			return runBatch(opts, groupBy, groupDepth)
+		},
+	}
+	batchCmd.Flags().StringVar(&groupBy, "group-by", "dir", "How to group changes: dir")
+	batchCmd.Flags().IntVar(&groupDepth, "group-depth", 1, "Number of leading directories that name a group with --group-by dir")
 
-	rootCmd.Flags().StringVarP(&opts.model, "model", "m", "gpt-4o-2024-08-06", "The model to use")
+	rootCmd.Flags().StringVarP(&opts.model, "model", "m", "", "The model to use (default: the provider's), or an alias such as fast or best (see lazycommit.alias.* in git config)")
+	rootCmd.Flags().StringVar(&opts.smallModel, "small-model", "", "The model to use for small diffs with --auto-model (default: the provider's)")
+	rootCmd.Flags().BoolVar(&opts.autoModel, "auto-model", false, "Use --small-model for diffs below --auto-model-threshold")
+	rootCmd.Flags().IntVar(&opts.autoThreshold, "auto-model-threshold", 2000, "Diff token count below which --auto-model picks --small-model")
+	rootCmd.Flags().IntVar(&opts.tokenBudget, "token-budget", 0, "Maximum prompt tokens (default: the model's context window)")
 	rootCmd.Flags().StringVar(&openAIKey, "openai-key", "", "The OpenAI API key")
-	rootCmd.Flags().StringVar(&opts.openAIBaseURL, "openai-base-url", "https://api.openai.com/v1", "The base URL for OpenAI API")
+	rootCmd.Flags().StringVar(&openAIProject, "openai-project", "", "The OpenAI project ID for project-scoped keys (default $OPENAI_PROJECT)")
+	rootCmd.Flags().StringVar(&opItem, "op-item", "", "Read the API key from 1Password with this secret reference, e.g. op://Private/OpenAI/credential")
+	rootCmd.Flags().StringVar(&providerName, "provider", "openai", "The model provider ("+strings.Join(providerNames(), ", ")+")")
+	rootCmd.Flags().StringVar(&opts.openAIBaseURL, "openai-base-url", "", "The base URL for the provider's API (default: the provider's own)")
+	rootCmd.Flags().StringVar(&opts.modelParams, "model-params", "", "JSON object of extra request fields, e.g. '{\"presence_penalty\":0.2}'")
+	rootCmd.Flags().BoolVar(&opts.noUsage, "no-usage", false, "Don't request token usage, for servers that reject stream_options")
+	rootCmd.Flags().IntVar(&opts.historyDepth, "history-depth", 300, "Maximum number of recent commits read for context")
+	rootCmd.Flags().StringVar(&gitPath, "git-path", "", "The git executable to run (default $"+gitBinaryEnv+" or git on PATH)")
+	rootCmd.Flags().DurationVar(&gitTimeout, "git-timeout", gitTimeout, "Timeout for git subprocesses (0 for none)")
+	rootCmd.Flags().IntVar(&maxRetries, "max-retries", 3, "Maximum retries when rate limited by the API")
+	rootCmd.Flags().IntVar(&opts.retryOnEmpty, "retry-on-empty", 1, "Times to regenerate when the model returns an empty message")
+	rootCmd.Flags().BoolVar(&opts.strictStream, "strict-stream", false, "Fail instead of committing when the response doesn't end with finish_reason stop")
+	rootCmd.Flags().IntVar(&opts.minConfidence, "min-confidence", 0, "Have the model rate the message 0-100 and regenerate once below this (0 to skip)")
 	rootCmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "d", false, "Dry run the commit command")
 	rootCmd.Flags().BoolVarP(&opts.amend, "amend", "a", false, "Amend the last commit")
+	rootCmd.Flags().StringVar(&opts.onEmpty, "on-empty", "error", "What to do when nothing is staged: error, all (commit all tracked changes) or prompt")
+	rootCmd.Flags().BoolVar(&opts.allowEmpty, "allow-empty", false, "Allow committing with no changes, describing the commit from --context")
+	rootCmd.Flags().BoolVar(&opts.includeStatus, "include-status", false, "Include a list of added, deleted and renamed files in the prompt")
+	rootCmd.Flags().StringSliceVar(&opts.includeExts, "include-ext", nil, "Only include diffs for files with these extensions, e.g. .go,.proto")
+	rootCmd.Flags().BoolVar(&opts.excludeLockfiles, "exclude-lockfiles", true, "List dependency lock files such as go.sum by name instead of including their diffs")
+	rootCmd.Flags().StringVar(&opts.diffSource, "diff-source", "staged", "What to describe: staged, working (all tracked changes, committed with --all), head or range:<a>..<b>")
+	rootCmd.Flags().StringVar(&opts.diffFile, "diff-file", "", "Describe the diff in this file, or - for stdin, instead of running git diff; prints the message without committing")
+	rootCmd.Flags().StringVar(&opts.diffAlgorithm, "diff-algorithm", "", "The git diff algorithm ("+strings.Join(diffAlgorithms, ", ")+")")
+	rootCmd.Flags().StringVar(&opts.diffFilter, "diff-filter", "", "Only describe files with these git diff statuses, e.g. A for added or ACMR (see git diff --diff-filter)")
+	rootCmd.Flags().BoolVar(&opts.stripDiffHeaders, "strip-diff-headers", false, "Replace each file's diff --git, index and ---/+++ lines with a \"file: path\" line to save tokens")
+	rootCmd.Flags().IntVar(&opts.largeDiffWarn, "large-diff-warn", 2000, "Warn when the diff exceeds this many lines (0 to disable)")
+	rootCmd.Flags().IntVar(&opts.maxDiffBytes, "max-diff-bytes", 0, "Abort when the raw diff exceeds this many bytes (0 for no limit)")
+	rootCmd.Flags().BoolVar(&opts.skipWhitespace, "skip-whitespace", false, "Ignore whitespace changes in the diff sent to the model")
+	rootCmd.Flags().BoolVar(&opts.summarizeBinary, "summarize-binary", false, "Describe binary files by size and image dimensions")
+	rootCmd.Flags().StringArrayVar(&opts.redact, "redact", nil, "Replace matches of this regular expression in the prompt with [REDACTED]")
 	rootCmd.Flags().StringSliceVarP(&opts.context, "context", "c", nil, "Additional context for commit message")
+	rootCmd.Flags().StringArrayVar(&opts.contextFiles, "context-file", nil, "Read additional context from a file, or - for stdin")
+	rootCmd.Flags().BoolVar(&opts.contextClipboard, "context-clipboard", false, "Add the clipboard's contents as additional context")
+	rootCmd.Flags().StringVar(&opts.contextPosition, "context-position", "after", "Where to put --context in the prompt: before or after the diff")
+	rootCmd.Flags().StringVar(&opts.contextMode, "context-mode", "must", "How strictly to apply --context: must or hint")
+	rootCmd.Flags().StringVar(&opts.promptFile, "prompt-file", "", "Replace the system prompt with this file's contents (default "+repoPromptFilename+" if present)")
+	rootCmd.Flags().BoolVar(&opts.smartPrompt, "smart-prompt", false, "Add hints for the languages in the diff, such as Go or SQL")
+	rootCmd.Flags().StringVar(&opts.templateFile, "commit-template-file", "", "Fill in this git commit template based on the diff")
+	rootCmd.Flags().BoolVar(&opts.conventional, "conventional", false, "Generate a Conventional Commit message")
+	rootCmd.Flags().StringVar(&opts.voice, "voice", "imperative", "The voice of the message: imperative, we or third-person")
+	rootCmd.Flags().BoolVar(&opts.gitmoji, "gitmoji", false, "Start the subject with a gitmoji for the kind of change")
+	rootCmd.Flags().StringVar(&opts.emojiMapFile, "emoji-map-file", "", "JSON file of change type to emoji, overriding the built-in gitmoji (implies --gitmoji)")
+	rootCmd.Flags().StringVar(&opts.scope, "scope", "", "Force the Conventional Commit scope")
+	rootCmd.Flags().BoolVar(&opts.scopeFromPackage, "scope-from-package", false, "Use the Go package with the most changed lines as the Conventional Commit scope, unless --scope is set")
+	rootCmd.Flags().BoolVar(&opts.breaking, "breaking", false, "Mark the Conventional Commit as a breaking change")
+	rootCmd.Flags().BoolVar(&opts.noBreaking, "no-breaking", false, "Never mark the Conventional Commit as a breaking change")
+	rootCmd.Flags().BoolVar(&opts.changelog, "changelog", false, "Print a user-facing changelog entry instead of committing")
+	rootCmd.Flags().StringVar(&opts.changelogFile, "changelog-file", "", "Add the changelog entry to the Unreleased section of this file (implies --changelog)")
+	rootCmd.Flags().BoolVar(&opts.lint, "lint", false, "Validate the generated message")
+	rootCmd.Flags().BoolVar(&opts.strict, "strict", false, "Fail instead of committing when validation fails (implies --lint)")
+	rootCmd.Flags().BoolVar(&opts.clean, "clean", true, "Strip boilerplate such as preambles and code fences from the message")
+	rootCmd.Flags().BoolVar(&opts.noNormalize, "no-normalize", false, "Keep trailing whitespace and repeated blank lines in the message")
+	rootCmd.Flags().BoolVar(&opts.subjectOnly, "subject-only", false, "Generate only a subject line, without a body")
+	rootCmd.Flags().BoolVar(&opts.body, "body", false, "Always allow a body, even for trivial changes")
+	rootCmd.Flags().IntVar(&opts.maxBodyLines, "max-body-lines", 0, "Maximum lines in the message body, excluding trailers (0 for no limit)")
+	rootCmd.Flags().BoolVar(&opts.prependTicket, "prepend-ticket", false, "Keep a leading ticket such as ABC-123 from the commit message file")
+	rootCmd.Flags().BoolVar(&opts.issueFromBranch, "issue-from-branch", false, "Add a Closes trailer for the issue number in the branch name")
+	rootCmd.Flags().StringVar(&opts.issueBaseURL, "issue-base-url", "", "Issue tracker URL for --issue-from-branch (default derived from origin)")
+	rootCmd.Flags().StringVar(&opts.tag, "tag", "", "Tag the new commit with this name")
+	rootCmd.Flags().StringVar(&opts.tagMessage, "tag-message", "", "Make the --tag an annotated tag with this message")
+	rootCmd.Flags().StringVar(&opts.preHook, "pre-hook", "", "Run this shell command before generating, aborting if it fails")
+	rootCmd.Flags().StringVar(&opts.postHook, "post-hook", "", "Run this shell command after committing, with $"+postHookCommitEnv+" set to the new commit")
+	rootCmd.Flags().BoolVar(&opts.trailingNewline, "trailing-newline", true, "End the committed message or message file with a newline, as git does")
+	rootCmd.Flags().BoolVar(&opts.noVerify, "no-verify", false, "Bypass git commit hooks")
+	rootCmd.Flags().StringVar(&opts.fixup, "fixup", "", "Commit the staged changes as a fixup! of this commit, for git rebase --autosquash (no message is generated)")
+	rootCmd.Flags().StringVar(&opts.squash, "squash", "", "Commit the staged changes as a squash! of this commit, with a generated message")
+	rootCmd.Flags().BoolVar(&opts.squashTrailer, "squash-trailer", false, "Add a trailer with the number of squashed commits for base..head ranges")
+	rootCmd.Flags().BoolVar(&opts.bodyFromCommits, "body-from-commits", false, "For base..head ranges, list the commit subjects as the body and generate only the subject")
+	rootCmd.Flags().StringArrayVar(&opts.trailers, "trailer", nil, "Add a \"Key: Value\" trailer to the message")
+	rootCmd.Flags().BoolVarP(&opts.signoff, "signoff", "s", false, "Add a Signed-off-by trailer")
+	rootCmd.Flags().BoolVar(&opts.changeID, "change-id", false, "Add a Gerrit Change-Id trailer, keeping the existing one when amending")
+	rootCmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Print the prompt sent to the model")
+	rootCmd.Flags().IntVar(&opts.verboseDiffLimit, "verbose-diff-limit", 200, "Maximum diff lines to print in verbose mode (0 for no limit)")
+	rootCmd.Flags().BoolVar(&opts.jsonMode, "json", false, "Print the message and errors as JSON instead of committing")
+	rootCmd.Flags().StringVar(&opts.jsonOutput, "json-output", "", "Also write the message and model as JSON to this file")
+	rootCmd.Flags().BoolVar(&noColor, "no-color", false, "Disable colored output")
+	rootCmd.Flags().BoolVar(&wrapCodeBlocks, "wrap-code-blocks", false, "Soft-wrap fenced and indented code in the displayed message")
+	rootCmd.Flags().BoolVar(&opts.timing, "timing", false, "Print generation latency to stderr")
+	rootCmd.Flags().BoolVar(&opts.dumpPrompt, "dump-prompt", false, "Print the prompt as JSON without calling the API")
+	rootCmd.Flags().BoolVar(&opts.estimate, "estimate", false, "Print the estimated prompt cost and ask before calling the API")
+	rootCmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation")
+	rootCmd.Flags().BoolVar(&opts.force, "force", false, "Amend commits even if they have been pushed")
 
 	rootCmd.Version = version
 	rootCmd.SetVersionTemplate("{{.Version}}\n")
 
-	rootCmd.AddCommand(CompletionCmd)
+	// The stash command shares the generation flags.
+	stashCmd.Flags().AddFlagSet(rootCmd.Flags())
+	rewordCmd.Flags().AddFlagSet(rootCmd.Flags())
+	noteCmd.Flags().AddFlagSet(rootCmd.Flags())
+	batchCmd.Flags().AddFlagSet(rootCmd.Flags())
+	compareCmd.Flags().AddFlagSet(rootCmd.Flags())
+	doctorCmd.Flags().AddFlagSet(rootCmd.Flags())
+
+	rootCmd.AddCommand(CompletionCmd, stashCmd, rewordCmd, noteCmd, batchCmd, compareCmd, doctorCmd, initAliasCmd)
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		if opts.jsonMode {
+			_ = writeJSONError(os.Stdout, err)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(exitCode(err))
 	}
 }
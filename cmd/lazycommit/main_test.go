@@ -0,0 +1,944 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/sashabaranov/go-openai"
+)
+
+// initTestRepo creates an empty git repository and changes into it for the
+// rest of the test. Git config outside the repository is ignored.
+func initTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(home, "gitconfig"))
+	// Fixed dates keep commit ids stable within a test.
+	t.Setenv("GIT_AUTHOR_DATE", "2024-05-01T12:00:00Z")
+	t.Setenv("GIT_COMMITTER_DATE", "2024-05-01T12:00:00Z")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	testGit(t, "init", "--quiet", "--initial-branch=main")
+	testGit(t, "config", "user.name", "Test User")
+	testGit(t, "config", "user.email", "test@example.com")
+	testGit(t, "config", "commit.gpgSign", "false")
+	return dir
+}
+
+// testGit runs git in the current directory, failing the test on error.
+func testGit(t *testing.T, args ...string) string {
+	t.Helper()
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// writeTestFile writes content to the slash-separated path, creating its
+// directories.
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	path = filepath.FromSlash(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// capture returns what f writes to *stream, which is os.Stdout or
+// os.Stderr.
+func capture(t *testing.T, stream **os.File, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	saved := *stream
+	*stream = file
+	defer func() { *stream = saved }()
+
+	f()
+	b, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// setStdin makes os.Stdin read input for the rest of the test.
+func setStdin(t *testing.T, input string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdin
+	os.Stdin = file
+	t.Cleanup(func() {
+		os.Stdin = saved
+		file.Close()
+	})
+}
+
+// testAPI is a fake chat completions endpoint that answers every request
+// with the next of replies, repeating the last.
+type testAPI struct {
+	mu       sync.Mutex
+	replies  []string
+	requests []openai.ChatCompletionRequest
+	// onRequest, if set, is called before each reply.
+	onRequest func()
+	// rejectStreamOptions makes requests with stream_options fail, as
+	// some OpenAI-compatible servers do.
+	rejectStreamOptions bool
+	// truncated ends streams without a finish reason, as if the
+	// connection dropped.
+	truncated bool
+}
+
+func newTestClient(t *testing.T, replies ...string) (*openai.Client, *testAPI) {
+	t.Helper()
+	api := &testAPI{replies: replies}
+	return newServerClient(t, api), api
+}
+
+// newServerClient returns a client for an API served by h.
+func newServerClient(t *testing.T, h http.Handler) *openai.Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	config := openai.DefaultConfig("sk-test")
+	config.BaseURL = srv.URL + "/v1"
+	return openai.NewClientWithConfig(config)
+}
+
+func (api *testAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if api.rejectStreamOptions && req.StreamOptions != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"message": "Unrecognized request argument supplied: stream_options", "type": "invalid_request_error"}}`)
+		return
+	}
+	api.mu.Lock()
+	api.requests = append(api.requests, req)
+	reply := api.replies[min(len(api.requests), len(api.replies))-1]
+	api.mu.Unlock()
+	if api.onRequest != nil {
+		api.onRequest()
+	}
+
+	if !req.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model: req.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(v any) {
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+	}
+	for _, word := range strings.SplitAfter(reply, " ") {
+		send(openai.ChatCompletionStreamResponse{
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: word}}},
+		})
+	}
+	if !api.truncated {
+		send(openai.ChatCompletionStreamResponse{
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonStop}},
+		})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// prompt returns the text of every message sent in the i'th request.
+func (api *testAPI) prompt(i int) string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var b strings.Builder
+	for _, m := range api.requests[i].Messages {
+		b.WriteString(m.Content + "\n")
+	}
+	return b.String()
+}
+
+func (api *testAPI) requestCount() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return len(api.requests)
+}
+
+// testRunOptions returns the options tests start from, with the flag
+// defaults that run depends on.
+func testRunOptions(client *openai.Client) runOptions {
+	return runOptions{
+		client:           client,
+		model:            "gpt-4o",
+		contextMode:      "must",
+		contextPosition:  "after",
+		onEmpty:          "error",
+		voice:            "imperative",
+		trailingNewline:  true,
+		verboseDiffLimit: 200,
+	}
+}
+
+// newErrorClient returns a client for an API that fails every request with
+// status.
+func newErrorClient(t *testing.T, status int) *openai.Client {
+	t.Helper()
+	return newServerClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error": {"message": %q, "type": "invalid_request_error"}}`, http.StatusText(status))
+	}))
+}
+
+func TestTruncateLines(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{s: "a\nb\nc", n: 0, want: "a\nb\nc"},
+		{s: "a\nb\nc", n: 3, want: "a\nb\nc"},
+		{s: "a\nb\nc", n: 5, want: "a\nb\nc"},
+		{s: "a\nb\nc\nd", n: 2, want: "a\nb\n... (truncated, 2 more lines)"},
+		{s: "a\nb", n: 1, want: "a\n... (truncated, 1 more lines)"},
+	}
+	for _, tt := range tests {
+		if got := truncateLines(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateLines(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestVerboseTruncatesOnlyTheLog(t *testing.T) {
+	initTestRepo(t)
+	var content strings.Builder
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	writeTestFile(t, "a.txt", content.String())
+	testGit(t, "add", ".")
+
+	client, api := newTestClient(t, "Add a.txt")
+	opts := testRunOptions(client)
+	opts.dryRun = true
+	opts.verbose = true
+	opts.verboseDiffLimit = 10
+	var err error
+	log := capture(t, &os.Stderr, func() { err = run(opts) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(log, "+line 3\n") || strings.Contains(log, "+line 50") {
+		t.Errorf("the diff in the log isn't cut to 10 lines:\n%s", log)
+	}
+	if !strings.Contains(log, "more lines)") {
+		t.Errorf("the log has no truncation marker:\n%s", log)
+	}
+	prompt := api.prompt(0)
+	for _, line := range []string{"+line 1\n", "+line 25\n", "+line 50\n"} {
+		if !strings.Contains(prompt, line) {
+			t.Errorf("the request is missing %q:\n%s", line, prompt)
+		}
+	}
+}
+
+func TestLooksLikeOpenAIKey(t *testing.T) {
+	tests := map[string]bool{
+		"sk-aaaaaaaaaaaaaaaaaaaaaaaa":      true,
+		"sk-proj-aaaaaaaaaaaaaaaaaaaaaaaa": true,
+		"sk-short":                         false,
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaa":     false,
+		"":                                 false,
+	}
+	for key, want := range tests {
+		if got := looksLikeOpenAIKey(key); got != want {
+			t.Errorf("looksLikeOpenAIKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestExplainAPIError(t *testing.T) {
+	plain := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		err      error
+		rejected bool
+	}{
+		{name: "api error 401", err: &openai.APIError{HTTPStatusCode: 401, Message: "bad key"}, rejected: true},
+		{name: "request error 401", err: &openai.RequestError{HTTPStatusCode: 401, Err: plain}, rejected: true},
+		{name: "wrapped 401", err: fmt.Errorf("stream: %w", &openai.APIError{HTTPStatusCode: 401}), rejected: true},
+		{name: "api error 500", err: &openai.APIError{HTTPStatusCode: 500, Message: "oops"}},
+		{name: "other error", err: plain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := explainAPIError(tt.err)
+			if !errors.Is(got, tt.err) {
+				t.Errorf("explainAPIError() = %v, which doesn't wrap %v", got, tt.err)
+			}
+			if rejected := strings.Contains(got.Error(), "API key was rejected"); rejected != tt.rejected {
+				t.Errorf("explainAPIError() = %q, rejected %v, want %v", got, rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestRunExplainsRejectedKey(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	for status, rejected := range map[int]bool{401: true, 500: false} {
+		opts := testRunOptions(newErrorClient(t, status))
+		opts.dryRun = true
+		err := run(opts)
+		if err == nil {
+			t.Fatalf("run() with a %d from the API succeeded", status)
+		}
+		if got := strings.Contains(err.Error(), "your API key was rejected"); got != rejected {
+			t.Errorf("status %d: run() = %v", status, err)
+		}
+	}
+}
+
+func TestMaxBodyLines(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	long := "Add a.txt\n\n- one\n- two\n- three\n\nRefs: #1"
+	client, api := newTestClient(t, long, long)
+	opts := testRunOptions(client)
+	opts.maxBodyLines = 2
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The model is asked to condense the body, and what it still gets
+	// wrong is cut.
+	if !strings.Contains(api.prompt(1), "Condense it to at most 2 lines") {
+		t.Errorf("the second request doesn't ask for a shorter body:\n%s", api.prompt(1))
+	}
+	want := "Add a.txt\n\n- one\n- two\n\nRefs: #1"
+	if got := testGit(t, "log", "-1", "--format=%B"); got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
+
+func TestRejectsStreamOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unrecognized argument", err: &openai.APIError{HTTPStatusCode: 400, Message: "Unrecognized request argument supplied: stream_options"}, want: true},
+		{name: "unknown field", err: &openai.APIError{HTTPStatusCode: 422, Message: "unknown field `stream_options`"}, want: true},
+		{name: "request error", err: &openai.RequestError{HTTPStatusCode: 400, Err: errors.New("stream_options is not supported")}, want: true},
+		{name: "other bad request", err: &openai.APIError{HTTPStatusCode: 400, Message: "messages is too long"}},
+		{name: "server error", err: &openai.APIError{HTTPStatusCode: 500, Message: "stream_options"}},
+		{name: "not an API error", err: errors.New("stream_options")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rejectsStreamOptions(fmt.Errorf("stream: %w", tt.err)); got != tt.want {
+				t.Errorf("rejectsStreamOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryWithoutStreamOptions(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	for _, noUsage := range []bool{false, true} {
+		client, api := newTestClient(t, "Add a.txt")
+		api.rejectStreamOptions = true
+		opts := testRunOptions(client)
+		opts.dryRun = true
+		opts.noUsage = noUsage
+		var err error
+		log := capture(t, &os.Stderr, func() { err = run(opts) })
+		if err != nil {
+			t.Fatalf("noUsage=%v: %v", noUsage, err)
+		}
+		if api.requestCount() != 1 || api.requests[0].StreamOptions != nil {
+			t.Errorf("noUsage=%v: the server answered %d requests, want 1 without stream_options", noUsage, api.requestCount())
+		}
+		if warned := strings.Contains(log, "retrying without usage"); warned == noUsage {
+			t.Errorf("noUsage=%v: stderr = %q", noUsage, log)
+		}
+	}
+}
+
+func TestPipedOutputHasNoEscapes(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	saved := colorProfile
+	t.Cleanup(func() { colorProfile = saved })
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		profile func() termenv.Profile
+		escapes bool
+	}{
+		{name: "piped", profile: termenv.EnvColorProfile},
+		{
+			name:    "NO_COLOR beats CLICOLOR_FORCE",
+			env:     map[string]string{"NO_COLOR": "1", "CLICOLOR_FORCE": "1"},
+			profile: termenv.EnvColorProfile,
+		},
+		{
+			name:    "forced color",
+			profile: func() termenv.Profile { return termenv.TrueColor },
+			escapes: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			client, _ := newTestClient(t, "Add a.txt\n\nWith a body.")
+			opts := testRunOptions(client)
+			opts.dryRun = true
+			opts.verbose = true
+			var err error
+			var stderr string
+			stdout := capture(t, &os.Stdout, func() {
+				// The profile is chosen for stdout as it is when run starts.
+				colorProfile = tt.profile()
+				stderr = capture(t, &os.Stderr, func() { err = run(opts) })
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(stdout, "Add a.txt") {
+				t.Errorf("stdout = %q, want the message", stdout)
+			}
+			if got := strings.Contains(stdout+stderr, "\x1b"); got != tt.escapes {
+				t.Errorf("escapes in output = %v, want %v:\n%q", got, tt.escapes, stdout+stderr)
+			}
+		})
+	}
+}
+
+func TestRewordHead(t *testing.T) {
+	initTestRepo(t)
+	const id = "I0123456789abcdef0123456789abcdef01234567"
+	testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "wip: the first line\n\nChange-Id: "+id)
+	// Staged changes are left out of the reworded commit.
+	writeTestFile(t, "b.txt", "staged\n")
+	testGit(t, "add", "b.txt")
+	tree := testGit(t, "rev-parse", "HEAD^{tree}")
+
+	client, api := newTestClient(t, "Add a.txt\n\nIt holds the first line.")
+	opts := testRunOptions(client)
+	opts.ref = "HEAD"
+	opts.rewordHead = true
+	opts.changeID = true
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if prompt := api.prompt(0); !strings.Contains(prompt, "+one") || !strings.Contains(prompt, "wip: the first line") {
+		t.Errorf("the prompt doesn't describe HEAD and its message:\n%s", prompt)
+	}
+	want := "Add a.txt\n\nIt holds the first line.\n\nChange-Id: " + id
+	if got := testGit(t, "log", "-1", "--format=%B"); got != want {
+		t.Errorf("HEAD message = %q, want %q", got, want)
+	}
+	if got := testGit(t, "rev-list", "--count", "HEAD"); got != "2" {
+		t.Errorf("HEAD has %s commits, want 2", got)
+	}
+	if got := testGit(t, "rev-parse", "HEAD^{tree}"); got != tree {
+		t.Error("rewording changed the tree")
+	}
+	if got := testGit(t, "diff", "--cached", "--name-only"); got != "b.txt" {
+		t.Errorf("staged after reword: %q, want b.txt", got)
+	}
+}
+
+func TestRewordMessageFile(t *testing.T) {
+	dir := initTestRepo(t)
+	const id = "I0123456789abcdef0123456789abcdef01234567"
+	testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "wip\n\nChange-Id: "+id)
+	commit := testGit(t, "rev-parse", "HEAD")
+	writeTestFile(t, "a.txt", "two\n")
+	testGit(t, "commit", "--quiet", "-am", "later")
+
+	// During a rebase git passes the message file of the commit being
+	// reworded, which need not be HEAD.
+	messageFile := filepath.Join(dir, ".git", "COMMIT_EDITMSG")
+	writeTestFile(t, filepath.ToSlash(messageFile), "wip\n\nChange-Id: "+id+"\n")
+
+	client, api := newTestClient(t, "Add a.txt")
+	opts := testRunOptions(client)
+	opts.ref = commit
+	opts.messageFile = messageFile
+	opts.changeID = true
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if prompt := api.prompt(0); !strings.Contains(prompt, "+one") || strings.Contains(prompt, "+two") {
+		t.Errorf("the prompt doesn't describe %s:\n%s", commit, prompt)
+	}
+	b, err := os.ReadFile(messageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Add a.txt\n\nChange-Id: " + id + "\n"; string(b) != want {
+		t.Errorf("message file = %q, want %q", b, want)
+	}
+	if got := testGit(t, "log", "-1", "--format=%s"); got != "later" {
+		t.Errorf("HEAD was changed to %q", got)
+	}
+}
+
+func TestCommitChangeID(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+
+	client, _ := newTestClient(t, "Add a.txt")
+	opts := testRunOptions(client)
+	opts.changeID = true
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	msg := testGit(t, "log", "-1", "--format=%B")
+	subject, trailer, _ := strings.Cut(msg, "\n\n")
+	if subject != "Add a.txt" || !changeIDRe.MatchString(trailer) {
+		t.Errorf("message = %q, want a Change-Id trailer", msg)
+	}
+}
+
+func TestStrictStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		truncated bool
+		strict    bool
+		wantErr   bool
+	}{
+		{name: "finished", strict: true},
+		{name: "truncated", truncated: true},
+		{name: "truncated and strict", truncated: true, strict: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestRepo(t)
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+
+			client, api := newTestClient(t, "Add a.txt")
+			api.truncated = tt.truncated
+			opts := testRunOptions(client)
+			opts.strictStream = tt.strict
+			err := run(opts)
+			if tt.wantErr {
+				if !errors.Is(err, errAPI) || !strings.Contains(err.Error(), "finish_reason: none") {
+					t.Errorf("run() = %v, want an API error naming the missing finish reason", err)
+				}
+				if _, err := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD").Output(); err == nil {
+					t.Error("a truncated response was committed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := testGit(t, "log", "-1", "--format=%s"); got != "Add a.txt" {
+				t.Errorf("HEAD subject = %q, want %q", got, "Add a.txt")
+			}
+		})
+	}
+}
+
+func TestDiffSource(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    []string
+		notWant []string
+	}{
+		{source: "staged", want: []string{"+staged"}, notWant: []string{"+three", "+two"}},
+		{source: "working", want: []string{"+staged", "+three"}, notWant: []string{"+two"}},
+		{source: "head", want: []string{"+two"}, notWant: []string{"+staged", "+three"}},
+		{source: "range:HEAD~1..HEAD", want: []string{"+two"}, notWant: []string{"+staged", "+three"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			initTestRepo(t)
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+			testGit(t, "commit", "--quiet", "-m", "initial")
+			writeTestFile(t, "a.txt", "two\n")
+			testGit(t, "commit", "--quiet", "-am", "second")
+			writeTestFile(t, "b.txt", "staged\n")
+			testGit(t, "add", "b.txt")
+			writeTestFile(t, "a.txt", "three\n")
+
+			client, api := newTestClient(t, "Change a.txt")
+			opts := testRunOptions(client)
+			opts.diffSource = tt.source
+			opts.dryRun = true
+			capture(t, &os.Stdout, func() {
+				if err := run(opts); err != nil {
+					t.Fatal(err)
+				}
+			})
+			prompt := api.prompt(0)
+			for _, s := range tt.want {
+				if !strings.Contains(prompt, s) {
+					t.Errorf("the prompt doesn't contain %q:\n%s", s, prompt)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(prompt, s) {
+					t.Errorf("the prompt contains %q:\n%s", s, prompt)
+				}
+			}
+		})
+	}
+
+	for _, source := range []string{"index", "range:HEAD"} {
+		opts := runOptions{diffSource: source}
+		if err := applyDiffSource(&opts); err == nil {
+			t.Errorf("applyDiffSource(%q) succeeded", source)
+		}
+	}
+	opts := runOptions{diffSource: "head", amend: true}
+	if err := applyDiffSource(&opts); err == nil {
+		t.Error("applyDiffSource() accepted --diff-source with --amend")
+	}
+}
+
+func TestDiffSourceWorkingCommitsAll(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+	writeTestFile(t, "a.txt", "two\n")
+
+	client, _ := newTestClient(t, "Change a.txt")
+	opts := testRunOptions(client)
+	opts.diffSource = "working"
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := testGit(t, "status", "--porcelain"); got != "" {
+		t.Errorf("unstaged changes weren't committed: %q", got)
+	}
+}
+
+func TestOnEmpty(t *testing.T) {
+	tests := []struct {
+		mode       string
+		answer     string
+		wantErr    error
+		wantCommit bool
+	}{
+		{mode: "error", wantErr: errNoChanges},
+		{mode: "all", wantCommit: true},
+		{mode: "prompt", answer: "y\n", wantCommit: true},
+		{mode: "prompt", answer: "n\n", wantErr: errNoChanges},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+strings.TrimSpace(tt.answer), func(t *testing.T) {
+			initTestRepo(t)
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+			testGit(t, "commit", "--quiet", "-m", "initial")
+			writeTestFile(t, "a.txt", "two\n")
+
+			setStdin(t, tt.answer)
+
+			client, api := newTestClient(t, "Change a.txt")
+			opts := testRunOptions(client)
+			opts.onEmpty = tt.mode
+			var err error
+			capture(t, &os.Stderr, func() {
+				err = run(opts)
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("run() = %v, want %v", err, tt.wantErr)
+				}
+				if n := api.requestCount(); n != 0 {
+					t.Errorf("%d requests were sent for an empty staging area", n)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := testGit(t, "rev-list", "--count", "HEAD"); (got == "2") != tt.wantCommit {
+				t.Errorf("HEAD has %s commits, want a commit: %v", got, tt.wantCommit)
+			}
+			if tt.wantCommit && !strings.Contains(api.prompt(0), "+two") {
+				t.Errorf("the prompt doesn't describe the unstaged change:\n%s", api.prompt(0))
+			}
+		})
+	}
+}
+
+func TestTrailingNewline(t *testing.T) {
+	for _, trailingNewline := range []bool{true, false} {
+		want := "Add a.txt"
+		if trailingNewline {
+			want += "\n"
+		}
+
+		dir := initTestRepo(t)
+		writeTestFile(t, "a.txt", "one\n")
+		testGit(t, "add", ".")
+		messageFile := filepath.Join(dir, ".git", "COMMIT_EDITMSG")
+		client, _ := newTestClient(t, "Add a.txt")
+		opts := testRunOptions(client)
+		opts.trailingNewline = trailingNewline
+		opts.messageFile = messageFile
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(messageFile); err != nil || string(b) != want {
+			t.Errorf("trailingNewline=%v: message file = %q, %v, want %q", trailingNewline, b, err, want)
+		}
+
+		opts.messageFile = ""
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+		// testGit trims its output, so read the raw commit object.
+		raw, err := exec.Command("git", "cat-file", "commit", "HEAD").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, got, _ := strings.Cut(string(raw), "\n\n"); got != want {
+			t.Errorf("trailingNewline=%v: committed message = %q, want %q", trailingNewline, got, want)
+		}
+	}
+}
+
+func TestContextPosition(t *testing.T) {
+	for _, position := range []string{"before", "after"} {
+		initTestRepo(t)
+		writeTestFile(t, "a.txt", "one\n")
+		testGit(t, "add", ".")
+
+		client, api := newTestClient(t, "Add a.txt")
+		opts := testRunOptions(client)
+		opts.context = []string{"It fixes the login bug."}
+		opts.contextPosition = position
+		opts.dryRun = true
+		capture(t, &os.Stdout, func() {
+			if err := run(opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		diffAt, contextAt := -1, -1
+		for i, m := range api.requests[0].Messages {
+			switch {
+			case strings.Contains(m.Content, "+one"):
+				diffAt = i
+			case strings.Contains(m.Content, "It fixes the login bug."):
+				contextAt = i
+			}
+		}
+		if diffAt < 0 || contextAt < 0 {
+			t.Fatalf("%s: the request lacks the diff (%d) or the context (%d)", position, diffAt, contextAt)
+		}
+		if (contextAt < diffAt) != (position == "before") {
+			t.Errorf("%s: the context is message %d and the diff message %d", position, contextAt, diffAt)
+		}
+	}
+
+	opts := testRunOptions(nil)
+	opts.contextPosition = "middle"
+	if err := run(opts); err == nil || !strings.Contains(err.Error(), "invalid --context-position") {
+		t.Errorf("run() with --context-position middle = %v", err)
+	}
+}
+
+func TestTrivialChange(t *testing.T) {
+	const trivialPrompt = "This change is trivial."
+	tests := []struct {
+		name  string
+		lines int
+		set   func(t *testing.T, opts *runOptions)
+		want  bool
+	}{
+		{name: "one line", lines: 1, want: true},
+		{name: "just below the threshold", lines: trivialChangedLines - 1, want: true},
+		{name: "at the threshold", lines: trivialChangedLines, want: false},
+		{name: "--body", lines: 1, set: func(t *testing.T, opts *runOptions) { opts.body = true }},
+		{name: "--subject-only", lines: 1, set: func(t *testing.T, opts *runOptions) { opts.subjectOnly = true }},
+		{name: "template", lines: 1, set: func(t *testing.T, opts *runOptions) {
+			writeTestFile(t, ".git/template.txt", "Summary:\n\nTesting:\n")
+			opts.templateFile = ".git/template.txt"
+		}},
+		{name: "note", lines: 1, set: func(t *testing.T, opts *runOptions) {
+			testGit(t, "commit", "--quiet", "-m", "wip")
+			opts.ref = "HEAD"
+			opts.note = true
+		}},
+		{name: "changelog", lines: 1, set: func(t *testing.T, opts *runOptions) { opts.changelog = true }},
+		{name: "allow-empty", lines: 1, set: func(t *testing.T, opts *runOptions) { opts.allowEmpty = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestRepo(t)
+			testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+			writeTestFile(t, "a.txt", strings.Repeat("line\n", tt.lines))
+			testGit(t, "add", ".")
+
+			client, api := newTestClient(t, "Add a.txt")
+			opts := testRunOptions(client)
+			opts.dryRun = true
+			if tt.set != nil {
+				tt.set(t, &opts)
+			}
+			capture(t, &os.Stdout, func() {
+				if err := run(opts); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if got := strings.Contains(api.prompt(0), trivialPrompt); got != tt.want {
+				t.Errorf("asked for a subject only: %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunMinConfidence(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []string
+		want    string
+		wantErr error
+	}{
+		{name: "confident", replies: []string{"feat: x", "90"}, want: "feat: x"},
+		{name: "regenerated", replies: []string{"feat: x", "40", "feat: y", "90"}, want: "feat: y"},
+		{name: "still unsure", replies: []string{"feat: x", "40", "feat: y", "50"}, wantErr: errLint},
+		// The regenerated reply gets the same checks as the first one.
+		{name: "regenerated empty", replies: []string{"feat: x", "40", " "}, wantErr: errAPI},
+		{name: "regenerated too long", replies: []string{"feat: x", "40", "Here is the commit message:\n```\nfeat: y\n\n- one\n- two\n- three\n```", "90"}, want: "feat: y\n\n- one\n- two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestRepo(t)
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+
+			client, _ := newTestClient(t, tt.replies...)
+			opts := testRunOptions(client)
+			opts.clean = true
+			opts.maxBodyLines = 2
+			opts.minConfidence = 70
+			err := run(opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("run() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := testGit(t, "log", "-1", "--format=%B"); got != tt.want {
+				t.Errorf("message = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxBodyLinesCleansFirst(t *testing.T) {
+	const fenced = "Here is the commit message:\n```\nfeat: x\n\n- one\n- two\n- three\n\nCloses: #1\n```"
+	tests := []struct {
+		maxBodyLines int
+		requests     int
+		want         string
+	}{
+		// Only the three bullets count, so the message already fits.
+		{maxBodyLines: 3, requests: 1, want: "feat: x\n\n- one\n- two\n- three\n\nCloses: #1"},
+		// The regenerated reply is cleaned before it is trimmed too.
+		{maxBodyLines: 2, requests: 2, want: "feat: x\n\n- one\n- two\n\nCloses: #1"},
+	}
+	for _, tt := range tests {
+		initTestRepo(t)
+		writeTestFile(t, "a.txt", "one\n")
+		testGit(t, "add", ".")
+
+		client, api := newTestClient(t, fenced, fenced)
+		opts := testRunOptions(client)
+		opts.clean = true
+		opts.maxBodyLines = tt.maxBodyLines
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+		if n := api.requestCount(); n != tt.requests {
+			t.Errorf("maxBodyLines=%d: made %d requests, want %d", tt.maxBodyLines, n, tt.requests)
+		}
+		if got := testGit(t, "log", "-1", "--format=%B"); got != tt.want {
+			t.Errorf("maxBodyLines=%d: message = %q, want %q", tt.maxBodyLines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// splitMessage splits msg into its subject line, body and trailer block.
+func splitMessage(msg string) (subject string, body string, trailers []string) {
+	rest, trailers := splitTrailers(msg)
+	subject, body, _ = strings.Cut(rest, "\n")
+	return subject, strings.Trim(body, "\n"), trailers
+}
+
+func joinMessage(subject string, body string, trailers []string) string {
+	parts := []string{subject}
+	if body != "" {
+		parts = append(parts, body)
+	}
+	if len(trailers) > 0 {
+		parts = append(parts, strings.Join(trailers, "\n"))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// countBodyLines returns the number of non-blank body lines in msg. The
+// subject and trailers are not counted.
+func countBodyLines(msg string) int {
+	_, body, _ := splitMessage(msg)
+	var n int
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// trimBodyLines keeps only the first n non-blank body lines of msg,
+// preserving the subject and trailers.
+func trimBodyLines(msg string, n int) string {
+	subject, body, trailers := splitMessage(msg)
+	var (
+		kept  []string
+		count int
+	)
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) != "" {
+			if count == n {
+				break
+			}
+			count++
+		}
+		kept = append(kept, line)
+	}
+	return joinMessage(subject, strings.TrimRight(strings.Join(kept, "\n"), "\n"), trailers)
+}
+
+// finishReply applies the checks every generated reply gets: an empty reply
+// is an error, clean strips boilerplate with cleanMessage and a positive
+// maxBodyLines trims the body.
+func finishReply(reply string, clean bool, maxBodyLines int) (string, error) {
+	if strings.TrimSpace(reply) == "" {
+		return "", fmt.Errorf("%w: the model returned an empty message", errAPI)
+	}
+	if clean {
+		reply = cleanMessage(reply)
+	}
+	if maxBodyLines > 0 {
+		reply = trimBodyLines(reply, maxBodyLines)
+	}
+	return reply, nil
+}
+
+var (
+	// preambleRe matches a leading "Here is the commit message:" style line,
+	// capturing any message text that follows on the same line.
+	preambleRe = regexp.MustCompile(`(?i)^(?:here(?:'s| is) (?:the|a|your) (?:suggested |generated |proposed )?commit message|(?:suggested |generated )?commit message)\s*:\s*(.*)$`)
+	// explanationRe matches a trailing paragraph commenting on the message.
+	// It only matches text that is clearly about the message itself, since
+	// a body may legitimately end with "Note: ...".
+	explanationRe = regexp.MustCompile(`(?i)^(?:this (?:commit )?message\b|let me know\b)`)
+)
+
+// cleanMessage strips common LLM boilerplate from a generated message: a
+// leading preamble, surrounding code fences and a trailing explanation.
+func cleanMessage(msg string) string {
+	msg = strings.TrimSpace(msg)
+
+	if first, rest, _ := strings.Cut(msg, "\n"); preambleRe.MatchString(first) {
+		inline := strings.TrimSpace(preambleRe.FindStringSubmatch(first)[1])
+		msg = strings.TrimSpace(inline + "\n" + rest)
+	}
+
+	// Keep only the contents of a fenced block, dropping anything after it.
+	if strings.HasPrefix(msg, "```") {
+		_, inner, _ := strings.Cut(msg, "\n")
+		if end := strings.Index(inner, "```"); end >= 0 {
+			inner = inner[:end]
+		}
+		msg = strings.TrimSpace(inner)
+	}
+
+	paragraphs := strings.Split(msg, "\n\n")
+	if n := len(paragraphs); n > 1 && explanationRe.MatchString(strings.TrimSpace(paragraphs[n-1])) {
+		msg = strings.TrimSpace(strings.Join(paragraphs[:n-1], "\n\n"))
+	}
+	return msg
+}
+
+// normalizeWhitespace trims trailing whitespace from each line of msg and
+// collapses runs of blank lines into one, so paragraphs, including the
+// subject, stay separated by exactly one blank line.
+func normalizeWhitespace(msg string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(strings.TrimSpace(msg), "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" && blank {
+			continue
+		}
+		blank = line == ""
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// messageDiff renders a line diff from old to new in unified style, with
+// every line shown since commit messages are short.
+func messageDiff(old string, new string) string {
+	a := strings.Split(strings.TrimRight(old, "\n"), "\n")
+	b := strings.Split(strings.TrimRight(new, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("--- current message\n+++ new message\n")
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString(" " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			out.WriteString("+" + b[j] + "\n")
+			j++
+		default:
+			out.WriteString("-" + a[i] + "\n")
+			i++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,174 @@
+package main
+
+import "testing"
+
+func TestCountBodyLines(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want int
+	}{
+		{msg: "fix: typo", want: 0},
+		{msg: "fix: typo\n\nOne.\n\nTwo.\nThree.", want: 3},
+		{msg: "fix: typo\n\nOne.\n\nRefs: #1\nSigned-off-by: A <a@example.com>", want: 1},
+	}
+	for _, tt := range tests {
+		if got := countBodyLines(tt.msg); got != tt.want {
+			t.Errorf("countBodyLines(%q) = %d, want %d", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestTrimBodyLines(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		n    int
+		want string
+	}{
+		{
+			name: "under the limit",
+			msg:  "fix: typo\n\nOne.",
+			n:    3,
+			want: "fix: typo\n\nOne.",
+		},
+		{
+			name: "blank lines are not counted",
+			msg:  "fix: typo\n\nOne.\n\nTwo.\nThree.",
+			n:    2,
+			want: "fix: typo\n\nOne.\n\nTwo.",
+		},
+		{
+			name: "trailers are kept",
+			msg:  "fix: typo\n\nOne.\nTwo.\n\nRefs: #1",
+			n:    1,
+			want: "fix: typo\n\nOne.\n\nRefs: #1",
+		},
+		{
+			name: "no body",
+			msg:  "fix: typo\n\nOne.\n\nRefs: #1",
+			n:    0,
+			want: "fix: typo\n\nRefs: #1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimBodyLines(tt.msg, tt.n); got != tt.want {
+				t.Errorf("trimBodyLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{
+			name: "clean",
+			msg:  "fix: typo\n\nBody.",
+			want: "fix: typo\n\nBody.",
+		},
+		{
+			name: "preamble",
+			msg:  "Here's the commit message:\n\nfix: typo",
+			want: "fix: typo",
+		},
+		{
+			name: "inline preamble",
+			msg:  "Suggested commit message: fix: typo",
+			want: "fix: typo",
+		},
+		{
+			name: "code fence",
+			msg:  "```text\nfix: typo\n\nBody.\n```\nHope this helps!",
+			want: "fix: typo\n\nBody.",
+		},
+		{
+			name: "trailing explanation",
+			msg:  "fix: typo\n\nBody.\n\nThis message follows the conventional commits format.",
+			want: "fix: typo\n\nBody.",
+		},
+		{
+			name: "trailing request for feedback",
+			msg:  "fix: typo\n\nLet me know if you want changes.",
+			want: "fix: typo",
+		},
+		{
+			name: "body ending in a note",
+			msg:  "fix: typo\n\nNote: the old spelling is still accepted.",
+			want: "fix: typo\n\nNote: the old spelling is still accepted.",
+		},
+		{
+			name: "body about this change",
+			msg:  "fix: typo\n\nThis change renames the flag.",
+			want: "fix: typo\n\nThis change renames the flag.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanMessage(tt.msg); got != tt.want {
+				t.Errorf("cleanMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{msg: "fix: typo", want: "fix: typo"},
+		{msg: "\n\nfix: typo  \n\n\n\nBody. \t\n\n", want: "fix: typo\n\nBody."},
+		{msg: "fix: typo\n  \n\t\nOne.\nTwo.", want: "fix: typo\n\nOne.\nTwo."},
+		{msg: "fix: typo\n\n    indented code", want: "fix: typo\n\n    indented code"},
+	}
+	for _, tt := range tests {
+		if got := normalizeWhitespace(tt.msg); got != tt.want {
+			t.Errorf("normalizeWhitespace(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestMessageDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{
+			name: "unchanged",
+			old:  "fix: typo\n",
+			new:  "fix: typo",
+			want: "--- current message\n+++ new message\n fix: typo\n",
+		},
+		{
+			name: "changed subject",
+			old:  "fix: typo\n\nBody.",
+			new:  "fix: spelling\n\nBody.",
+			want: "--- current message\n+++ new message\n+fix: spelling\n-fix: typo\n \n Body.\n",
+		},
+		{
+			name: "added line",
+			old:  "fix: typo",
+			new:  "fix: typo\n\nBody.",
+			want: "--- current message\n+++ new message\n fix: typo\n+\n+Body.\n",
+		},
+		{
+			name: "removed line",
+			old:  "fix: typo\n\nBody.\nMore.",
+			new:  "fix: typo\n\nBody.",
+			want: "--- current message\n+++ new message\n fix: typo\n \n Body.\n-More.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageDiff(tt.old, tt.new); got != tt.want {
+				t.Errorf("messageDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import "strings"
+
+// modelInfo describes a model's limits and pricing.
+type modelInfo struct {
+	// contextWindow is the context window in tokens.
+	contextWindow int
+	// inputPrice is the price in USD per million prompt tokens.
+	inputPrice float64
+}
+
+// models is the table of known models. Dated snapshots such as
+// gpt-4o-2024-08-06 match by prefix.
+var models = map[string]modelInfo{
+	"gpt-4o":        {contextWindow: 128000, inputPrice: 2.50},
+	"gpt-4o-mini":   {contextWindow: 128000, inputPrice: 0.15},
+	"gpt-4-turbo":   {contextWindow: 128000, inputPrice: 10},
+	"gpt-4":         {contextWindow: 8192, inputPrice: 30},
+	"gpt-4-32k":     {contextWindow: 32768, inputPrice: 60},
+	"gpt-3.5-turbo": {contextWindow: 16385, inputPrice: 0.50},
+	"o1":            {contextWindow: 200000, inputPrice: 15},
+	"o1-mini":       {contextWindow: 128000, inputPrice: 3},
+	"o1-preview":    {contextWindow: 128000, inputPrice: 15},
+}
+
+// defaultContextWindow is assumed for models not in models. It is small
+// enough to fit most models.
+const defaultContextWindow = 16000
+
+// lookupModel returns the entry for model, using the longest matching
+// prefix in models.
+func lookupModel(model string) (info modelInfo, ok bool) {
+	var match string
+	for name, m := range models {
+		if (model == name || strings.HasPrefix(model, name+"-")) && len(name) > len(match) {
+			match, info = name, m
+		}
+	}
+	return info, match != ""
+}
+
+// contextWindow returns the context window of model. ok is false for
+// unknown models.
+func contextWindow(model string) (tokens int, ok bool) {
+	info, ok := lookupModel(model)
+	if !ok {
+		return defaultContextWindow, false
+	}
+	return info.contextWindow, true
+}
+
+// promptCost returns the price in USD of sending tokens prompt tokens to
+// model. ok is false when the model's pricing is unknown.
+func promptCost(model string, tokens int) (cost float64, ok bool) {
+	info, ok := lookupModel(model)
+	if !ok {
+		return 0, false
+	}
+	return float64(tokens) * info.inputPrice / 1e6, true
+}
+
+// modelAliases are built-in short names for models. Aliases can also be set
+// in git config as lazycommit.alias.<name>, which takes precedence.
+var modelAliases = map[string]string{
+	"fast": "gpt-4o-mini",
+	"best": "gpt-4o-2024-08-06",
+}
+
+// resolveModelAlias returns the model id for name, or name itself if it
+// isn't an alias.
+func resolveModelAlias(name string) string {
+	if model, err := gitConfig("lazycommit.alias." + name); err == nil && model != "" {
+		return model
+	}
+	if model, ok := modelAliases[name]; ok {
+		return model
+	}
+	return name
+}
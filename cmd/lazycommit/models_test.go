@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestLookupModel(t *testing.T) {
+	tests := []struct {
+		model  string
+		window int
+		ok     bool
+	}{
+		{model: "gpt-4o", window: 128000, ok: true},
+		{model: "gpt-4o-2024-08-06", window: 128000, ok: true},
+		{model: "gpt-4", window: 8192, ok: true},
+		{model: "gpt-4-0613", window: 8192, ok: true},
+		{model: "gpt-4-32k-0613", window: 32768, ok: true},
+		{model: "o1-mini-2024-09-12", window: 128000, ok: true},
+		{model: "gpt-4omni", ok: false},
+		{model: "llama3", ok: false},
+	}
+	for _, tt := range tests {
+		info, ok := lookupModel(tt.model)
+		if ok != tt.ok || info.contextWindow != tt.window {
+			t.Errorf("lookupModel(%q) = %d, %v, want %d, %v", tt.model, info.contextWindow, ok, tt.window, tt.ok)
+		}
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	if got, ok := contextWindow("llama3"); got != defaultContextWindow || ok {
+		t.Errorf("contextWindow(\"llama3\") = %d, %v, want %d, false", got, ok, defaultContextWindow)
+	}
+	if got, ok := contextWindow("gpt-3.5-turbo-0125"); got != 16385 || !ok {
+		t.Errorf("contextWindow(\"gpt-3.5-turbo-0125\") = %d, %v, want 16385, true", got, ok)
+	}
+}
+
+func TestPromptCost(t *testing.T) {
+	if got, ok := promptCost("gpt-4o-mini", 2_000_000); got != 0.30 || !ok {
+		t.Errorf("promptCost() = %v, %v, want 0.3, true", got, ok)
+	}
+	if _, ok := promptCost("llama3", 1000); ok {
+		t.Error("promptCost() of an unknown model succeeded")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// reservedModelParams can't be set with --model-params because lazycommit
+// depends on them.
+var reservedModelParams = []string{"messages", "stream"}
+
+// requestFields returns the JSON names of the ChatCompletionRequest fields.
+func requestFields() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(openai.ChatCompletionRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// applyModelParams merges the JSON object params into req. It returns the
+// names of fields the client doesn't know, which are dropped.
+func applyModelParams(req *openai.ChatCompletionRequest, params string) (unknown []string, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(params), &fields); err != nil {
+		return nil, fmt.Errorf("invalid --model-params: %w", err)
+	}
+	known := requestFields()
+	for name := range fields {
+		for _, reserved := range reservedModelParams {
+			if name == reserved {
+				return nil, fmt.Errorf("invalid --model-params: %q can't be overridden", name)
+			}
+		}
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	if err := json.Unmarshal([]byte(params), req); err != nil {
+		return nil, fmt.Errorf("invalid --model-params: %w", err)
+	}
+	return unknown, nil
+}
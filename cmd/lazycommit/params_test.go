@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestApplyModelParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		check   func(req openai.ChatCompletionRequest) bool
+		unknown []string
+		wantErr bool
+	}{
+		{
+			name:   "known fields",
+			params: `{"temperature": 0.2, "seed": 7, "max_tokens": 100}`,
+			check: func(req openai.ChatCompletionRequest) bool {
+				return req.Temperature == 0.2 && req.Seed != nil && *req.Seed == 7 && req.MaxTokens == 100
+			},
+		},
+		{
+			name:    "unknown fields",
+			params:  `{"top_k": 40, "min_p": 0.1, "temperature": 1}`,
+			check:   func(req openai.ChatCompletionRequest) bool { return req.Temperature == 1 },
+			unknown: []string{"min_p", "top_k"},
+		},
+		{
+			name:   "model can be overridden",
+			params: `{"model": "gpt-4o-mini"}`,
+			check:  func(req openai.ChatCompletionRequest) bool { return req.Model == "gpt-4o-mini" },
+		},
+		{name: "messages are reserved", params: `{"messages": []}`, wantErr: true},
+		{name: "stream is reserved", params: `{"stream": false}`, wantErr: true},
+		{name: "not an object", params: `[1]`, wantErr: true},
+		{name: "wrong type", params: `{"temperature": "hot"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := openai.ChatCompletionRequest{
+				Model:    "gpt-4o",
+				Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+				Stream:   true,
+			}
+			unknown, err := applyModelParams(&req, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyModelParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !tt.check(req) {
+				t.Errorf("applyModelParams() gave %+v", req)
+			}
+			if !slices.Equal(unknown, tt.unknown) {
+				t.Errorf("unknown = %q, want %q", unknown, tt.unknown)
+			}
+			if len(req.Messages) != 1 || !req.Stream {
+				t.Errorf("applyModelParams() changed messages or stream: %+v", req)
+			}
+		})
+	}
+}
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -16,8 +16,14 @@ import (
 	"github.com/tiktoken-go/tokenizer"
 )
 
+// getEncoder returns the token encoder. Building it is expensive and it is
+// used for every commit message in the history, so it is loaded only once.
+var getEncoder = sync.OnceValues(func() (tokenizer.Codec, error) {
+	return tokenizer.Get(tokenizer.Cl100kBase)
+})
+
 func CountTokens(msgs ...openai.ChatCompletionMessage) int {
-	enc, err := tokenizer.Get(tokenizer.Cl100kBase)
+	enc, err := getEncoder()
 	if err != nil {
 		panic("oh oh")
 	}
@@ -37,7 +43,7 @@ func CountTokens(msgs ...openai.ChatCompletionMessage) int {
 
 // Ellipse returns a string that is truncated to the maximum number of tokens.
 func Ellipse(s string, maxTokens int) string {
-	enc, err := tokenizer.Get(tokenizer.Cl100kBase)
+	enc, err := getEncoder()
 	if err != nil {
 		panic("failed to get tokenizer")
 	}
@@ -84,6 +90,12 @@ func findGitRoot(dir string) (string, error) {
 	}
 }
 
+const (
+	repoPromptFilename  = ".lazycommit/prompt.md"
+	defaultSystemPrompt = "You are a tool called `lazycommit` that generates high quality commit messages for git diffs.\n" +
+		"Generate only the commit message, without any additional text."
+)
+
 const (
 	styleGuideFilename    = "COMMITS.md"
 	defaultUserStyleGuide = `
@@ -104,6 +116,22 @@ const (
 `
 )
 
+// voicePrompts override the style guide's imperative mood. The default
+// imperative voice needs no extra instruction.
+var voicePrompts = map[string]string{
+	"imperative":   "",
+	"we":           `Instead of the imperative mood, write in the first person plural, e.g. "We now cache results".`,
+	"third-person": `Instead of the imperative mood, write in the third person present tense, e.g. "Caches results".`,
+}
+
+func voicePrompt(voice string) (string, error) {
+	prompt, ok := voicePrompts[voice]
+	if !ok {
+		return "", fmt.Errorf("invalid voice %q: must be imperative, we or third-person", voice)
+	}
+	return prompt, nil
+}
+
 // findRepoStyleGuide searches for "COMMITS.md" in the repository root of dir
 // and returns its contents.
 func findRepoStyleGuide(dir string) (string, error) {
@@ -137,53 +165,246 @@ func findUserStyleGuide() (string, error) {
 	return strings.TrimSpace(string(styleGuide)), nil
 }
 
-func BuildPrompt(
-	log io.Writer,
-	dir string,
-	commitHash string,
-	amend bool,
-	maxTokens int,
-) ([]openai.ChatCompletionMessage, error) {
+// findSystemPrompt returns the system prompt to use. An explicit promptFile
+// takes precedence over a repository's prompt file, which takes precedence
+// over the built-in prompt.
+func findSystemPrompt(gitRoot string, promptFile string) (string, error) {
+	if promptFile != "" {
+		b, err := os.ReadFile(promptFile)
+		if err != nil {
+			return "", fmt.Errorf("read prompt file: %w", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(gitRoot, repoPromptFilename))
+	if err == nil {
+		if prompt := strings.TrimSpace(string(b)); prompt != "" {
+			return prompt, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("read repo prompt: %w", err)
+	}
+	return defaultSystemPrompt, nil
+}
+
+// promptOptions configures BuildPrompt.
+type promptOptions struct {
+	// dir is the working directory inside the repository.
+	dir string
+	// commitHash is the commit being described, or "" for staged changes.
+	commitHash string
+	amend      bool
+	maxTokens  int
+	// includeStatus adds a list of added, deleted and renamed files.
+	includeStatus bool
+	// historyDepth caps how many recent commits are read for context.
+	historyDepth int
+	// stash, if set, describes this stash instead of commits or staged
+	// changes.
+	stash string
+	// promptFile overrides the system prompt.
+	promptFile string
+	// includeExts limits the diff to files with these extensions. Other
+	// files are listed by name only.
+	includeExts []string
+	// diffAlgorithm, if set, is passed to git diff as --diff-algorithm.
+	diffAlgorithm string
+	// allowEmpty accepts an empty diff, for commits described purely by
+	// the user's context.
+	allowEmpty bool
+	// largeDiffWarn is the diff size, in lines, above which the user is
+	// advised to split the commit. Zero disables the warning.
+	largeDiffWarn int
+	// skipWhitespace leaves whitespace changes out of the diff, unless
+	// they're all there is.
+	skipWhitespace bool
+	// summarizeBinary describes binary files by size and, for images,
+	// dimensions.
+	summarizeBinary bool
+	// workingTree describes all tracked changes, staged or not, instead of
+	// only staged ones.
+	workingTree bool
+	// excludeLockfiles lists dependency lock files by name only.
+	excludeLockfiles bool
+	// diffText, if set, is a pre-computed diff used instead of running git
+	// diff. The directory then need not be a repository; if it isn't, the
+	// prompt has no commit history.
+	diffText string
+	// diffFilter, if set, is passed to git diff as --diff-filter.
+	diffFilter string
+	// maxDiffBytes, if positive, is the largest raw diff accepted.
+	maxDiffBytes int
+}
+
+// emptyCommitNote stands in for the diff of an intentionally empty commit.
+const emptyCommitNote = "There are no code changes: this commit is intentionally empty. " +
+	"Write its message from the context provided."
+
+func BuildPrompt(log io.Writer, opts promptOptions) ([]openai.ChatCompletionMessage, error) {
+	gitRoot, err := findGitRoot(opts.dir)
+	if err != nil && opts.diffText == "" {
+		return nil, fmt.Errorf("find git root: %w", err)
+	}
+
+	systemPrompt, err := findSystemPrompt(gitRoot, opts.promptFile)
+	if err != nil {
+		return nil, err
+	}
 	resp := []openai.ChatCompletionMessage{
 		{
-			Role: openai.ChatMessageRoleSystem,
-			Content: strings.Join([]string{
-				"You are a tool called `lazycommit` that generates high quality commit messages for git diffs.",
-				"Generate only the commit message, without any additional text.",
-			}, "\n"),
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemPrompt,
 		},
 	}
 
-	gitRoot, err := findGitRoot(dir)
-	if err != nil {
-		return nil, fmt.Errorf("find git root: %w", err)
+	// Linked worktrees keep objects and refs in the main repository's
+	// common dir.
+	var repo *git.Repository
+	if gitRoot != "" {
+		repo, err = git.PlainOpenWithOptions(gitRoot, &git.PlainOpenOptions{
+			EnableDotGitCommonDir: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("open repo %q: %w", opts.dir, err)
+		}
 	}
 
-	repo, err := git.PlainOpen(gitRoot)
-	if err != nil {
-		return nil, fmt.Errorf("open repo %q: %w", dir, err)
+	var diffArgs []string
+	if opts.diffAlgorithm != "" {
+		diffArgs = append(diffArgs, "--diff-algorithm="+opts.diffAlgorithm)
+	}
+	if opts.diffFilter != "" {
+		diffArgs = append(diffArgs, "--diff-filter="+opts.diffFilter)
 	}
 
+	// Get the stash or working directory diff
+	diff := func(w io.Writer, args ...string) error {
+		switch {
+		case opts.diffText != "":
+			if len(args) > 0 {
+				return fmt.Errorf("git diff options %v can't be applied to a diff file", args)
+			}
+			_, err := io.WriteString(w, opts.diffText)
+			return err
+		case opts.stash != "":
+			return generateStashDiff(w, opts.dir, opts.stash, args...)
+		case opts.workingTree:
+			return generateWorkingTreeDiff(w, opts.dir, args...)
+		}
+		return generateDiff(w, opts.dir, opts.commitHash, opts.amend, args...)
+	}
 	var buf bytes.Buffer
-	// Get the working directory diff
-	if err := generateDiff(&buf, dir, commitHash, amend); err != nil {
+	if err := diff(&buf, diffArgs...); err != nil {
+		if opts.stash != "" {
+			return nil, fmt.Errorf("generate stash diff: %w", err)
+		}
 		return nil, fmt.Errorf("generate working directory diff: %w", err)
 	}
+	if opts.maxDiffBytes > 0 && buf.Len() > opts.maxDiffBytes {
+		return nil, fmt.Errorf("the diff is %d bytes, over --max-diff-bytes %d; split the commit, "+
+			"or narrow the diff with --include-ext or --skip-whitespace", buf.Len(), opts.maxDiffBytes)
+	}
 
-	if buf.Len() == 0 {
-		if commitHash == "" {
-			return nil, fmt.Errorf("no staged changes, nothing to commit")
+	switch {
+	case buf.Len() == 0 && opts.diffFilter != "":
+		return nil, fmt.Errorf("%w: no changes match --diff-filter %s", errNoChanges, opts.diffFilter)
+	case buf.Len() == 0 && opts.stash != "":
+		return nil, fmt.Errorf("%w: stash %q has no changes", errNoChanges, opts.stash)
+	case buf.Len() == 0 && opts.allowEmpty:
+		buf.WriteString(emptyCommitNote)
+	case buf.Len() == 0 && opts.commitHash == "":
+		return nil, fmt.Errorf("no staged changes, %w", errNoChanges)
+	case buf.Len() == 0:
+		return nil, fmt.Errorf("%w: no changes detected for %q", errNoChanges, opts.commitHash)
+	case opts.diffText != "":
+		// Whitespace changes can't be told apart without git.
+	default:
+		// git diff -w prints nothing when every change is whitespace.
+		var wsBuf bytes.Buffer
+		if err := diff(&wsBuf, append(diffArgs, "-w")...); err != nil {
+			return nil, fmt.Errorf("generate diff ignoring whitespace: %w", err)
+		}
+		if wsBuf.Len() == 0 {
+			resp = append(resp, openai.ChatCompletionMessage{
+				Role: openai.ChatMessageRoleSystem,
+				Content: "The changes are whitespace-only. Describe them as " +
+					"formatting changes.",
+			})
+		} else if opts.skipWhitespace {
+			buf = wsBuf
 		}
-		return nil, fmt.Errorf("no changes detected for %q", commitHash)
 	}
 
 	const minTokens = 5000
-	if maxTokens < minTokens {
-		return nil, fmt.Errorf("maxTokens must be greater than %d", minTokens)
+	if opts.maxTokens < minTokens {
+		return nil, fmt.Errorf("token budget must be at least %d", minTokens)
 	}
 
 	targetDiffString := buf.String()
 
+	if len(opts.includeExts) > 0 || opts.excludeLockfiles {
+		kept, omitted := filterDiff(splitDiff(targetDiffString), func(path string) bool {
+			if len(opts.includeExts) > 0 && !hasExtension(path, opts.includeExts) {
+				return false
+			}
+			return !opts.excludeLockfiles || !isLockfile(path)
+		})
+		if len(omitted) > 0 {
+			targetDiffString = joinDiff(kept) + omittedNote(omitted)
+		}
+	}
+
+	// Diffs this large tend to get vague messages even when they fit.
+	if lines := strings.Count(targetDiffString, "\n"); opts.largeDiffWarn > 0 && lines > opts.largeDiffWarn {
+		fmt.Fprintf(os.Stderr, "warning: the diff is %d lines; consider splitting it into smaller commits\n", lines)
+	}
+
+	if changes := parseSubmoduleChanges(targetDiffString); len(changes) > 0 {
+		var lines []string
+		for _, c := range changes {
+			lines = append(lines, c.describe(gitRoot))
+		}
+		resp = append(resp, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleSystem,
+			Content: "The diff includes submodule pointer changes:\n" +
+				strings.Join(lines, "\n"),
+		})
+	}
+
+	if opts.summarizeBinary {
+		summary, err := binarySummary(gitRoot, targetDiffString)
+		if err != nil {
+			return nil, fmt.Errorf("summarize binary files: %w", err)
+		}
+		if summary != "" {
+			resp = append(resp, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: summary,
+			})
+		}
+	}
+
+	if opts.includeStatus {
+		status, err := fileStatus(diff)
+		if err != nil {
+			return nil, fmt.Errorf("get file status: %w", err)
+		}
+		resp = append(resp, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "Files changed:\n" + status,
+		})
+	}
+
+	if repo == nil {
+		// Without a repository there is no history to add.
+		resp = append(resp, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: Ellipse(targetDiffString, opts.maxTokens-CountTokens(resp...)),
+		})
+		return resp, nil
+	}
+
 	// Get the HEAD reference
 	head, err := repo.Head()
 	if err != nil {
@@ -211,7 +432,7 @@ func BuildPrompt(
 
 	// Collect the last N commits
 	var commits []*object.Commit
-	for i := 0; i < 300; i++ {
+	for i := 0; i < opts.historyDepth; i++ {
 		commit, err := commitIter.Next()
 		if err == io.EOF {
 			break
@@ -221,7 +442,7 @@ func BuildPrompt(
 		}
 		// Ignore if commit equals ref, because we are trying to recalculate
 		// that particular commit's message.
-		if commit.Hash.String() == commitHash {
+		if commit.Hash.String() == opts.commitHash {
 			continue
 		}
 		commits = append(commits, commit)
@@ -246,7 +467,7 @@ func BuildPrompt(
 	)
 
 	// Add style guide after commit messages so it takes priority.
-	repoStyleGuide, err := findRepoStyleGuide(dir)
+	repoStyleGuide, err := findRepoStyleGuide(opts.dir)
 	if err != nil {
 		return nil, fmt.Errorf("find style guide: %w", err)
 	}
@@ -272,30 +493,177 @@ func BuildPrompt(
 
 	resp = append(resp, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
-		Content: Ellipse(targetDiffString, maxTokens-CountTokens(resp...)),
+		Content: Ellipse(targetDiffString, opts.maxTokens-CountTokens(resp...)),
 	})
 
 	return resp, nil
 }
 
-// generateDiff uses the git CLI to generate a diff for the given reference.
+// generateWorkingTreeDiff writes every change to tracked files since HEAD,
+// staged or not, to w. Any extraArgs are passed to git diff as options.
+func generateWorkingTreeDiff(w io.Writer, dir string, extraArgs ...string) error {
+	cmd, cancel := gitCommand("-C", dir, "diff")
+	defer cancel()
+	cmd.Args = append(cmd.Args, extraArgs...)
+	cmd.Args = append(cmd.Args, "HEAD")
+
+	var errBuf bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s %s: %w\n%s",
+			cmd.Args[0], strings.Join(cmd.Args[1:], " "), err, errBuf.String())
+	}
+	return nil
+}
+
+// generateStashDiff writes the changes recorded in stash to w. Any extraArgs
+// are passed to git stash show as diff options.
+func generateStashDiff(w io.Writer, dir string, stash string, extraArgs ...string) error {
+	cmd, cancel := gitCommand("-C", dir, "stash", "show", "-p")
+	defer cancel()
+	cmd.Args = append(cmd.Args, extraArgs...)
+	cmd.Args = append(cmd.Args, stash)
+
+	var errBuf bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s %s: %w\n%s",
+			cmd.Args[0], strings.Join(cmd.Args[1:], " "), err, errBuf.String())
+	}
+	return nil
+}
+
+// fileStatus lists the files changed by the diff, one "status: path" line
+// per file, so the model reliably notices additions, deletions and renames.
+// diff writes the diff being described with extraArgs as git diff options.
+func fileStatus(diff func(w io.Writer, extraArgs ...string) error) (string, error) {
+	var buf bytes.Buffer
+	if err := diff(&buf, "--name-status"); err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		status, paths := fields[0], fields[1:]
+		switch status[0] {
+		case 'A':
+			status = "added"
+		case 'D':
+			status = "deleted"
+		case 'M':
+			status = "modified"
+		case 'T':
+			status = "type changed"
+		case 'R':
+			status = "renamed"
+		case 'C':
+			status = "copied"
+		}
+		lines = append(lines, status+": "+strings.Join(paths, " -> "))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// submoduleChange is a gitlink update found in a diff.
+type submoduleChange struct {
+	path string
+	// old and new are empty when the submodule is added or removed.
+	old string
+	new string
+}
+
+// parseSubmoduleChanges finds "Subproject commit" hunks in diff.
+func parseSubmoduleChanges(diff string) []submoduleChange {
+	var (
+		changes []submoduleChange
+		current *submoduleChange
+	)
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = nil
+			if i := strings.LastIndex(line, " b/"); i >= 0 {
+				current = &submoduleChange{path: line[i+3:]}
+			}
+		case current == nil:
+		case strings.HasPrefix(line, "-Subproject commit "):
+			current.old = strings.TrimPrefix(line, "-Subproject commit ")
+		case strings.HasPrefix(line, "+Subproject commit "):
+			current.new = strings.TrimPrefix(line, "+Subproject commit ")
+		default:
+			continue
+		}
+		if current != nil && (current.old != "" || current.new != "") {
+			// Replace an entry already recorded for this file.
+			if n := len(changes); n > 0 && changes[n-1].path == current.path {
+				changes[n-1] = *current
+			} else {
+				changes = append(changes, *current)
+			}
+		}
+	}
+	return changes
+}
+
+// describe returns a readable summary of the change, including commit
+// subjects when the submodule is checked out under gitRoot.
+func (c submoduleChange) describe(gitRoot string) string {
+	ref := func(hash string) string {
+		short := hash
+		if len(short) > 7 {
+			short = short[:7]
+		}
+		cmd, cancel := gitCommand("-C", filepath.Join(gitRoot, c.path),
+			"log", "-1", "--format=%s", hash)
+		defer cancel()
+		out, err := cmd.Output()
+		if err != nil || len(bytes.TrimSpace(out)) == 0 {
+			return short
+		}
+		return fmt.Sprintf("%s (%q)", short, strings.TrimSpace(string(out)))
+	}
+	switch {
+	case c.old == "":
+		return fmt.Sprintf("add submodule %s at %s", c.path, ref(c.new))
+	case c.new == "":
+		return fmt.Sprintf("remove submodule %s at %s", c.path, ref(c.old))
+	default:
+		return fmt.Sprintf("bump submodule %s from %s to %s", c.path, ref(c.old), ref(c.new))
+	}
+}
+
+// generateDiff uses the git CLI to generate a diff for the given reference,
+// which may also be a base..head range.
 // If refName is empty, it will generate a diff of staged changes for the working directory.
-func generateDiff(w io.Writer, dir string, refName string, amend bool) error {
+// Any extraArgs are passed to git diff as options.
+func generateDiff(w io.Writer, dir string, refName string, amend bool, extraArgs ...string) error {
 	// Use the git CLI instead of go-git for more accurate and complete diff generation
-	cmd := exec.Command("git", "-C", dir, "diff")
+	cmd, cancel := gitCommand("-C", dir, "diff")
+	defer cancel()
+	cmd.Args = append(cmd.Args, extraArgs...)
 
 	if refName == "" {
 		// Case 1: No specific commit reference provided
 		// Generate diff for staged changes in the working directory
 		cmd.Args = append(cmd.Args, "--cached")
+	} else if isRange(refName) {
+		// Case 2: A base..head range is provided
+		// Show the combined changes of every commit in the range
+		cmd.Args = append(cmd.Args, refName)
 	} else {
-		// Case 2: A specific commit reference is provided
+		// Case 3: A specific commit reference is provided
 		if amend {
-			// Case 2a: Amending the specified commit
+			// Case 3a: Amending the specified commit
 			// Show diff of the commit being amended plus any staged changes
 			cmd.Args = append(cmd.Args, "--cached", refName+"^")
 		} else {
-			// Case 2b: Show changes introduced by the specific commit
+			// Case 3b: Show changes introduced by the specific commit
 			cmd.Args = append(cmd.Args, refName+"^", refName)
 		}
 	}
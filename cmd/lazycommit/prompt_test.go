@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGenerateStashDiff(t *testing.T) {
+	dir := initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+	writeTestFile(t, "a.txt", "stashed first\n")
+	testGit(t, "stash", "push", "--quiet")
+	writeTestFile(t, "a.txt", "stashed second\n")
+	testGit(t, "stash", "push", "--quiet")
+
+	for stash, want := range map[string]string{
+		"stash@{0}": "+stashed second",
+		"stash@{1}": "+stashed first",
+	} {
+		var b strings.Builder
+		if err := generateStashDiff(&b, dir, stash); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), want) || !strings.Contains(b.String(), "-one") {
+			t.Errorf("diff of %s:\n%s\nwant it to contain %q", stash, b.String(), want)
+		}
+	}
+
+	if err := generateStashDiff(&strings.Builder{}, dir, "stash@{5}"); err == nil {
+		t.Error("generateStashDiff() of a missing stash succeeded")
+	}
+}
+
+func TestRunStash(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+	writeTestFile(t, "a.txt", "stashed\n")
+	testGit(t, "stash", "push", "--quiet")
+	// Staged changes are not part of the stash.
+	writeTestFile(t, "b.txt", "staged\n")
+	testGit(t, "add", "b.txt")
+
+	client, api := newTestClient(t, "Change a.txt")
+	opts := testRunOptions(client)
+	opts.stash = "stash@{0}"
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := api.prompt(0)
+	if !strings.Contains(prompt, "+stashed") || strings.Contains(prompt, "b.txt") {
+		t.Errorf("the prompt doesn't describe only the stash:\n%s", prompt)
+	}
+	if got := testGit(t, "rev-list", "--count", "HEAD"); got != "1" {
+		t.Errorf("describing a stash made a commit: %s commits", got)
+	}
+	if got := testGit(t, "stash", "list"); strings.Count(got, "\n") != 0 || got == "" {
+		t.Errorf("stash list = %q, want the stash untouched", got)
+	}
+}
+
+func TestBuildPromptWhitespace(t *testing.T) {
+	const note = "The changes are whitespace-only."
+	dir := initTestRepo(t)
+	writeTestFile(t, "a.go", "package a\n\nfunc A() {\n\treturn\n}\n")
+	writeTestFile(t, "b.go", "package b\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "initial")
+
+	prompt := func(skip bool) string {
+		t.Helper()
+		msgs, err := BuildPrompt(io.Discard, promptOptions{dir: dir, maxTokens: 128000, skipWhitespace: skip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		for _, m := range msgs {
+			b.WriteString(m.Content + "\n")
+		}
+		return b.String()
+	}
+
+	// Reindenting a.go is the only change.
+	writeTestFile(t, "a.go", "package a\n\nfunc A() {\n    return\n}\n")
+	testGit(t, "add", ".")
+	for _, skip := range []bool{false, true} {
+		if got := prompt(skip); !strings.Contains(got, note) || !strings.Contains(got, "+    return") {
+			t.Errorf("skipWhitespace=%v: a whitespace-only prompt should note it and keep the diff:\n%s", skip, got)
+		}
+	}
+
+	// With a real change alongside, only --skip-whitespace drops a.go.
+	writeTestFile(t, "b.go", "package b\n\nvar B = 1\n")
+	testGit(t, "add", ".")
+	if got := prompt(false); strings.Contains(got, note) || !strings.Contains(got, "+    return") {
+		t.Errorf("without skipWhitespace the reindent should stay in the diff:\n%s", got)
+	}
+	if got := prompt(true); strings.Contains(got, "+    return") || !strings.Contains(got, "+var B = 1") {
+		t.Errorf("with skipWhitespace only b.go should be described:\n%s", got)
+	}
+}
+
+func TestRunDiffFile(t *testing.T) {
+	initTestRepo(t)
+	// The diff file is described without a repository.
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	const diff = "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-package a\n+package b\n"
+	writeTestFile(t, "change.diff", diff)
+	writeTestFile(t, "empty.diff", "\n")
+
+	client, api := newTestClient(t, "Rename package a to b")
+	opts := testRunOptions(client)
+	opts.diffFile = "change.diff"
+	out := capture(t, &os.Stdout, func() {
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(api.prompt(0), diff) {
+		t.Errorf("the prompt doesn't contain the diff file:\n%s", api.prompt(0))
+	}
+	if !strings.Contains(out, "Rename package a to b") {
+		t.Errorf("the message wasn't printed: %q", out)
+	}
+
+	opts.diffFile = "empty.diff"
+	if err := run(opts); !errors.Is(err, errNoChanges) {
+		t.Errorf("run() with an empty diff file = %v, want %v", err, errNoChanges)
+	}
+}
+
+func TestBuildPromptLargeDiffWarning(t *testing.T) {
+	dir := initTestRepo(t)
+	writeTestFile(t, "a.txt", strings.Repeat("line\n", 50))
+	testGit(t, "add", ".")
+
+	// Stdout can carry the message itself, so the warning must not go there.
+	var log strings.Builder
+	stderr := capture(t, &os.Stderr, func() {
+		if _, err := BuildPrompt(&log, promptOptions{dir: dir, maxTokens: 128000, largeDiffWarn: 20}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(stderr, "warning: the diff is") {
+		t.Errorf("stderr = %q, want the large diff warning", stderr)
+	}
+	if strings.Contains(log.String(), "warning") {
+		t.Errorf("the large diff warning went to the log: %q", log.String())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// providerConfig is passed to a provider's factory.
+type providerConfig struct {
+	apiKey string
+	// baseURL is empty to use the provider's default.
+	baseURL string
+	// project scopes requests to an OpenAI project, if set.
+	project    string
+	httpClient openai.HTTPDoer
+}
+
+// headerClient adds fixed headers to every request. go-openai has no
+// setting for the OpenAI-Project header.
+type headerClient struct {
+	next   openai.HTTPDoer
+	header http.Header
+}
+
+func (hc headerClient) Do(req *http.Request) (*http.Response, error) {
+	for k, v := range hc.header {
+		req.Header[k] = v
+	}
+	return hc.next.Do(req)
+}
+
+// provider is a model backend selectable with --provider.
+type provider struct {
+	name string
+	// keyEnv is the environment variable holding the API key. It is empty
+	// for providers that don't need a key.
+	keyEnv string
+	// validKey, if set, reports whether a key looks well formed. It is only
+	// used to warn, since gateways vary.
+	validKey func(key string) bool
+	// defaultModel is used when --model isn't given, and
+	// defaultSmallModel when --small-model isn't. --auto-model does
+	// nothing for providers without a small model.
+	defaultModel      string
+	defaultSmallModel string
+	newClient         func(cfg providerConfig) (*openai.Client, error)
+}
+
+var providers = map[string]provider{}
+
+// registerProvider makes p available by name. Registering a name twice is a
+// programming error.
+func registerProvider(p provider) {
+	if _, ok := providers[p.name]; ok {
+		panic(fmt.Sprintf("provider %q registered twice", p.name))
+	}
+	providers[p.name] = p
+}
+
+func lookupProvider(name string) (provider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return provider{}, fmt.Errorf("unknown provider %q, available: %s",
+			name, strings.Join(providerNames(), ", "))
+	}
+	return p, nil
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// openAICompatible returns a factory for providers that serve the OpenAI
+// chat API at defaultBaseURL.
+func openAICompatible(defaultBaseURL string) func(cfg providerConfig) (*openai.Client, error) {
+	return func(cfg providerConfig) (*openai.Client, error) {
+		config := openai.DefaultConfig(cfg.apiKey)
+		config.BaseURL = defaultBaseURL
+		if cfg.baseURL != "" {
+			config.BaseURL = cfg.baseURL
+		}
+		config.HTTPClient = cfg.httpClient
+		return openai.NewClientWithConfig(config), nil
+	}
+}
+
+func init() {
+	registerProvider(provider{
+		name:              "openai",
+		keyEnv:            "OPENAI_API_KEY",
+		validKey:          looksLikeOpenAIKey,
+		defaultModel:      "gpt-4o-2024-08-06",
+		defaultSmallModel: "gpt-4o-mini",
+		newClient: func(cfg providerConfig) (*openai.Client, error) {
+			config := openai.DefaultConfig(cfg.apiKey)
+			if cfg.baseURL != "" {
+				config.BaseURL = cfg.baseURL
+			}
+			config.HTTPClient = cfg.httpClient
+			if cfg.project != "" {
+				config.HTTPClient = headerClient{
+					next:   cfg.httpClient,
+					header: http.Header{"Openai-Project": {cfg.project}},
+				}
+			}
+			return openai.NewClientWithConfig(config), nil
+		},
+	})
+	registerProvider(provider{
+		name:              "anthropic",
+		keyEnv:            "ANTHROPIC_API_KEY",
+		defaultModel:      "claude-3-5-sonnet-latest",
+		defaultSmallModel: "claude-3-5-haiku-latest",
+		newClient:         openAICompatible("https://api.anthropic.com/v1"),
+	})
+	// A local Ollama server needs no key.
+	registerProvider(provider{
+		name:              "ollama",
+		defaultModel:      "llama3",
+		defaultSmallModel: "llama3.2",
+		newClient:         openAICompatible("http://localhost:11434/v1"),
+	})
+}
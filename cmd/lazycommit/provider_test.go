@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestLookupProvider(t *testing.T) {
+	p, err := lookupProvider("openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.name != "openai" || p.keyEnv != "OPENAI_API_KEY" || p.validKey == nil {
+		t.Errorf("lookupProvider(\"openai\") = %+v", p)
+	}
+
+	_, err = lookupProvider("nope")
+	if err == nil || !strings.Contains(err.Error(), `unknown provider "nope"`) || !strings.Contains(err.Error(), "available: anthropic, ollama, openai") {
+		t.Errorf("lookupProvider(\"nope\") = %v, want an error listing the providers", err)
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	registerProvider(provider{name: "test-local"})
+	t.Cleanup(func() { delete(providers, "test-local") })
+
+	if p, err := lookupProvider("test-local"); err != nil || p.keyEnv != "" {
+		t.Errorf("lookupProvider(\"test-local\") = %+v, %v", p, err)
+	}
+	if got := strings.Join(providerNames(), ","); got != "anthropic,ollama,openai,test-local" {
+		t.Errorf("providerNames() = %s, want anthropic,ollama,openai,test-local", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a provider twice didn't panic")
+		}
+	}()
+	registerProvider(provider{name: "test-local"})
+}
+
+func TestApplyModelDefaults(t *testing.T) {
+	tests := []struct {
+		provider             string
+		model, smallModel    string
+		wantModel, wantSmall string
+	}{
+		{provider: "openai", wantModel: "gpt-4o-2024-08-06", wantSmall: "gpt-4o-mini"},
+		{provider: "anthropic", wantModel: "claude-3-5-sonnet-latest", wantSmall: "claude-3-5-haiku-latest"},
+		{provider: "ollama", wantModel: "llama3", wantSmall: "llama3.2"},
+		{provider: "ollama", model: "qwen2.5-coder", wantModel: "qwen2.5-coder", wantSmall: "llama3.2"},
+		{provider: "anthropic", smallModel: "claude-3-haiku-20240307", wantModel: "claude-3-5-sonnet-latest", wantSmall: "claude-3-haiku-20240307"},
+		{provider: "openai", model: "gpt-4o", smallModel: "gpt-3.5-turbo", wantModel: "gpt-4o", wantSmall: "gpt-3.5-turbo"},
+	}
+	for _, tt := range tests {
+		p, err := lookupProvider(tt.provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := runOptions{model: tt.model, smallModel: tt.smallModel}
+		applyModelDefaults(&opts, p)
+		if opts.model != tt.wantModel || opts.smallModel != tt.wantSmall {
+			t.Errorf("%s with --model=%q --small-model=%q: got %q, %q, want %q, %q",
+				tt.provider, tt.model, tt.smallModel, opts.model, opts.smallModel, tt.wantModel, tt.wantSmall)
+		}
+	}
+}
+
+func TestOpenAICompatibleProviders(t *testing.T) {
+	for _, name := range []string{"anthropic", "ollama"} {
+		api := &testAPI{replies: []string{"ok"}}
+		srv := httptest.NewServer(api)
+
+		p, err := lookupProvider(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := p.newClient(providerConfig{baseURL: srv.URL + "/v1", httpClient: http.DefaultClient})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{Model: p.defaultModel, Stream: true})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		resp, err := stream.Recv()
+		stream.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := resp.Choices[0].Delta.Content; got != "ok" {
+			t.Errorf("the %s client got %q from the base URL, want ok", name, got)
+		}
+	}
+}
+
+func TestOpenAIProviderClient(t *testing.T) {
+	api := &testAPI{replies: []string{"ok"}}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	p, err := lookupProvider("openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := p.newClient(providerConfig{apiKey: "sk-test", baseURL: srv.URL + "/v1", httpClient: http.DefaultClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Choices[0].Delta.Content; got != "ok" {
+		t.Errorf("the client got %q from the base URL, want ok", got)
+	}
+}
+
+func TestOpenAIProject(t *testing.T) {
+	for _, project := range []string{"", "proj_123"} {
+		var got []string
+		api := &testAPI{replies: []string{"ok"}}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Values("OpenAI-Project")
+			api.ServeHTTP(w, r)
+		}))
+
+		p, err := lookupProvider("openai")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := p.newClient(providerConfig{apiKey: "sk-test", baseURL: srv.URL + "/v1", httpClient: http.DefaultClient, project: project})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{Model: "gpt-4o", Stream: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Close()
+		srv.Close()
+
+		switch {
+		case project == "" && len(got) != 0:
+			t.Errorf("OpenAI-Project = %q without a project", got)
+		case project != "" && (len(got) != 1 || got[0] != project):
+			t.Errorf("OpenAI-Project = %q, want %q", got, project)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const redactedText = "[REDACTED]"
+
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --redact pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// redactMessages replaces every match of res in the message contents. Only
+// the prompt is affected, never the files in the repository.
+func redactMessages(msgs []openai.ChatCompletionMessage, res []*regexp.Regexp) {
+	for i := range msgs {
+		for _, re := range res {
+			msgs[i].Content = re.ReplaceAllLiteralString(msgs[i].Content, redactedText)
+		}
+	}
+}
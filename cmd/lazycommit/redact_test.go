@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestCompileRedactPatterns(t *testing.T) {
+	res, err := compileRedactPatterns([]string{`\w+\.internal`, "ACME"})
+	if err != nil || len(res) != 2 {
+		t.Fatalf("compileRedactPatterns() = %v, %v", res, err)
+	}
+	if _, err := compileRedactPatterns([]string{"ok", "(unclosed"}); err == nil || !strings.Contains(err.Error(), "(unclosed") {
+		t.Errorf("compileRedactPatterns() of an invalid pattern = %v", err)
+	}
+}
+
+func TestRedactMessages(t *testing.T) {
+	res, err := compileRedactPatterns([]string{`[a-z]+\.internal`, "ACME Corp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "Write a message."},
+		{Role: openai.ChatMessageRoleUser, Content: "+host = db.internal\n+owner = ACME Corp, via api.internal"},
+	}
+	redactMessages(msgs, res)
+	if msgs[0].Content != "Write a message." {
+		t.Errorf("unmatched message changed: %q", msgs[0].Content)
+	}
+	if want := "+host = [REDACTED]\n+owner = [REDACTED], via [REDACTED]"; msgs[1].Content != want {
+		t.Errorf("redacted message = %q, want %q", msgs[1].Content, want)
+	}
+}
+
+func TestRunRedactsOnlyThePrompt(t *testing.T) {
+	initTestRepo(t)
+	const config = "host = db.corp.internal\nowner = ACME Corp\n"
+	writeTestFile(t, "config.ini", config)
+	testGit(t, "add", ".")
+
+	client, api := newTestClient(t, "Add the config")
+	opts := testRunOptions(client)
+	opts.redact = []string{`[a-z.]+\.internal`, "ACME Corp"}
+	opts.context = []string{"moved off db.corp.internal"}
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := api.prompt(0)
+	if strings.Contains(prompt, "internal") || strings.Contains(prompt, "ACME") {
+		t.Errorf("the prompt still has redacted text:\n%s", prompt)
+	}
+	if !strings.Contains(prompt, "+host = [REDACTED]") || !strings.Contains(prompt, "moved off [REDACTED]") {
+		t.Errorf("the prompt has no [REDACTED] markers:\n%s", prompt)
+	}
+	// The working tree and the commit keep the original text.
+	b, err := os.ReadFile("config.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != config {
+		t.Errorf("config.ini = %q, want %q", b, config)
+	}
+	if got := testGit(t, "show", "HEAD:config.ini"); got+"\n" != config {
+		t.Errorf("committed config.ini = %q, want %q", got, config)
+	}
+}
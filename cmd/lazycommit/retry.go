@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryClient is an HTTP client that retries rate limited (429) requests,
+// waiting for the server's Retry-After when given and backing off
+// exponentially otherwise.
+type retryClient struct {
+	client     *http.Client
+	maxRetries int
+	baseDelay  time.Duration
+	sleep      func(time.Duration)
+	now        func() time.Time
+}
+
+func newRetryClient(maxRetries int) *retryClient {
+	return &retryClient{
+		client:     http.DefaultClient,
+		maxRetries: maxRetries,
+		baseDelay:  time.Second,
+		sleep:      time.Sleep,
+		now:        time.Now,
+	}
+}
+
+func (c *retryClient) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= c.maxRetries {
+			return resp, err
+		}
+
+		delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), c.now())
+		if !ok {
+			delay = c.baseDelay << attempt
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("cannot retry %s: request body is not replayable", req.URL)
+			}
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewind request body: %w", err)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "rate limited, retrying in %s\n", delay)
+		c.sleep(delay)
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// readOpSecret reads a secret with the 1Password CLI, given a secret
+// reference such as op://Private/OpenAI/credential.
+func readOpSecret(ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "op", "read", "--no-newline", ref)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("%w: --op-item needs the 1Password CLI (op) installed", errMissingAPIKey)
+	}
+	if err != nil {
+		// op explains problems such as not being signed in on stderr. It
+		// never prints the secret there.
+		return "", fmt.Errorf("%w: op read %s: %s", errMissingAPIKey, ref, strings.TrimSpace(stderr.String()))
+	}
+	secret := strings.TrimSpace(string(output))
+	if secret == "" {
+		return "", fmt.Errorf("%w: op read %s returned an empty secret", errMissingAPIKey, ref)
+	}
+	return secret, nil
+}
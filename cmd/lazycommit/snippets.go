@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// promptSnippet is an instruction added to the prompt when a file matching
+// glob is in the diff. Snippets are set in git config, for example:
+//
+//	[lazycommit "migrations/*.sql"]
+//		snippet = Mention both the up and down migration.
+type promptSnippet struct {
+	glob string
+	text string
+}
+
+// readPromptSnippets returns the snippets in git config, in config order.
+func readPromptSnippets() ([]promptSnippet, error) {
+	cmd, cancel := gitCommand("config", "--null", "--get-regexp", `^lazycommit\..+\.snippet$`)
+	defer cancel()
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// No snippets are set.
+		return nil, nil
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		// --diff-file may be used without git installed.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read prompt snippets: %w", err)
+	}
+
+	var snippets []promptSnippet
+	for _, entry := range strings.Split(string(output), "\x00") {
+		key, text, _ := strings.Cut(entry, "\n")
+		glob := strings.TrimSuffix(strings.TrimPrefix(key, "lazycommit."), ".snippet")
+		if glob == key || strings.TrimSpace(text) == "" {
+			continue
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q in lazycommit.%s.snippet: %w", glob, glob, err)
+		}
+		snippets = append(snippets, promptSnippet{glob: glob, text: strings.TrimSpace(text)})
+	}
+	return snippets, nil
+}
+
+// matchesGlob reports whether file matches glob. Like .gitignore, a glob
+// without a slash matches the file's base name in any directory.
+func matchesGlob(glob string, file string) bool {
+	if !strings.Contains(glob, "/") {
+		file = path.Base(file)
+	}
+	ok, _ := path.Match(glob, file)
+	return ok
+}
+
+// snippetPrompt returns the snippets matching a file in diff, or "" if
+// none do.
+func snippetPrompt(snippets []promptSnippet, diff string) string {
+	files := splitDiff(diff)
+	var lines []string
+	for _, s := range snippets {
+		for _, f := range files {
+			if matchesGlob(s.glob, f.path) {
+				lines = append(lines, fmt.Sprintf("For files matching %s: %s", s.glob, s.text))
+				break
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Follow these instructions for the files they apply to:\n" + strings.Join(lines, "\n")
+}
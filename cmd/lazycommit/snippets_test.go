@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchesGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		file string
+		want bool
+	}{
+		{glob: "*.sql", file: "db/migrations/001.sql", want: true},
+		{glob: "migrations/*.sql", file: "migrations/001.sql", want: true},
+		{glob: "migrations/*.sql", file: "db/migrations/001.sql", want: false},
+		{glob: "*.sql", file: "schema.sql.md", want: false},
+	}
+	for _, tt := range tests {
+		if got := matchesGlob(tt.glob, tt.file); got != tt.want {
+			t.Errorf("matchesGlob(%q, %q) = %v, want %v", tt.glob, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestRunPromptSnippets(t *testing.T) {
+	const snippet = "Mention both the up and down migration."
+	initTestRepo(t)
+	testGit(t, "config", "lazycommit.migrations/*.sql.snippet", snippet)
+	testGit(t, "config", "lazycommit.*.proto.snippet", "Note wire compatibility.")
+	testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+
+	for _, tt := range []struct {
+		file string
+		want bool
+	}{
+		{file: "README.md", want: false},
+		{file: "migrations/002_users.sql", want: true},
+	} {
+		writeTestFile(t, tt.file, "change\n")
+		testGit(t, "add", tt.file)
+		client, api := newTestClient(t, "Change "+tt.file)
+		if err := run(testRunOptions(client)); err != nil {
+			t.Fatal(err)
+		}
+		prompt := api.prompt(0)
+		if got := strings.Contains(prompt, "For files matching migrations/*.sql: "+snippet); got != tt.want {
+			t.Errorf("staging %s: the prompt has the snippet: %v, want %v\n%s", tt.file, got, tt.want, prompt)
+		}
+		if strings.Contains(prompt, "wire compatibility") {
+			t.Errorf("staging %s: the prompt has the snippet for *.proto", tt.file)
+		}
+	}
+}
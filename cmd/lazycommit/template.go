@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stripCommentLines removes lines starting with "#", as git does when
+// cleaning up a commit message.
+func stripCommentLines(s string) string {
+	var kept []string
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// loadCommitTemplate reads a git commit template, without comment lines.
+func loadCommitTemplate(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read commit template: %w", err)
+	}
+	template := stripCommentLines(string(b))
+	if template == "" {
+		return "", fmt.Errorf("commit template %q is empty", path)
+	}
+	return template, nil
+}
+
+func commitTemplatePrompt(template string) string {
+	return "Write the commit message by filling in and expanding this template " +
+		"based on the diff. Keep its structure and headings:\n" + template
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ticketPrefixRe matches a leading ticket such as "[ABC-123] " or
+// "ABC-123: ". Group 2 or 3 is the ticket id.
+var ticketPrefixRe = regexp.MustCompile(`^(\[([A-Z][A-Z0-9]+-[0-9]+)\]|([A-Z][A-Z0-9]+-[0-9]+):?)\s`)
+
+// ticketPrefix returns the ticket prefix of the first line of msg that isn't
+// blank or a comment, and the bare ticket id. Both are "" if there is none.
+func ticketPrefix(msg string) (prefix string, id string) {
+	for _, line := range strings.Split(msg, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := ticketPrefixRe.FindStringSubmatch(line + " ")
+		if m == nil {
+			return "", ""
+		}
+		return m[1], m[2] + m[3]
+	}
+	return "", ""
+}
+
+// prependTicket puts prefix in front of msg, unless its subject already
+// mentions the ticket.
+func prependTicket(msg string, prefix string, id string) string {
+	subject, _, _ := strings.Cut(msg, "\n")
+	if strings.Contains(subject, id) {
+		return msg
+	}
+	return prefix + " " + msg
+}
+
+// readTicketPrefix reads the ticket prefix from a commit message file, such
+// as one prefilled by a prepare-commit-msg hook. A missing file has none.
+func readTicketPrefix(path string) (prefix string, id string, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	prefix, id = ticketPrefix(string(data))
+	return prefix, id, nil
+}
+
+// pendingTicketPrefix reads the ticket prefix from COMMIT_EDITMSG when git
+// didn't pass a message file, as in the normal and dry-run modes. A
+// prepare-commit-msg hook or an aborted commit can leave a ticket there,
+// but a file holding HEAD's message is left over from the last commit and
+// is ignored.
+func pendingTicketPrefix() (prefix string, id string, err error) {
+	path, err := gitOutput("rev-parse", "--git-path", "COMMIT_EDITMSG")
+	if err != nil {
+		return "", "", err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	// The first commit has no HEAD to compare with.
+	if head, err := gitOutput("log", "-1", "--format=%B", "HEAD"); err == nil && stripCommentLines(string(data)) == head {
+		return "", "", nil
+	}
+	prefix, id = ticketPrefix(string(data))
+	return prefix, id, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTicketPrefix(t *testing.T) {
+	tests := []struct {
+		msg    string
+		prefix string
+		id     string
+	}{
+		{msg: "[ABC-123] wip", prefix: "[ABC-123]", id: "ABC-123"},
+		{msg: "ABC-123: wip", prefix: "ABC-123:", id: "ABC-123"},
+		{msg: "# Please enter the commit message\n\nPROJ2-7\n", prefix: "PROJ2-7", id: "PROJ2-7"},
+		{msg: "fix: ABC-123 in the middle", prefix: "", id: ""},
+		{msg: "abc-123: lowercase", prefix: "", id: ""},
+		{msg: "", prefix: "", id: ""},
+	}
+	for _, tt := range tests {
+		if prefix, id := ticketPrefix(tt.msg); prefix != tt.prefix || id != tt.id {
+			t.Errorf("ticketPrefix(%q) = %q, %q, want %q, %q", tt.msg, prefix, id, tt.prefix, tt.id)
+		}
+	}
+}
+
+func TestPrependTicket(t *testing.T) {
+	if got, want := prependTicket("Fix the login\n\nBody.", "[ABC-123]", "ABC-123"), "[ABC-123] Fix the login\n\nBody."; got != want {
+		t.Errorf("prependTicket() = %q, want %q", got, want)
+	}
+	// A subject that already names the ticket is left alone.
+	if got, want := prependTicket("Fix ABC-123", "ABC-123:", "ABC-123"), "Fix ABC-123"; got != want {
+		t.Errorf("prependTicket() = %q, want %q", got, want)
+	}
+}
+
+func TestRunPrependTicketMessageFile(t *testing.T) {
+	dir := initTestRepo(t)
+	testGit(t, "commit", "--quiet", "--allow-empty", "-m", "initial")
+	writeTestFile(t, "a.txt", "one\n")
+	testGit(t, "add", ".")
+	testGit(t, "commit", "--quiet", "-m", "wip")
+
+	// A prepare-commit-msg hook filled in the ticket before git started
+	// the editor.
+	messageFile := filepath.Join(dir, ".git", "COMMIT_EDITMSG")
+	writeTestFile(t, filepath.ToSlash(messageFile), "ABC-123: \n# Comment\n")
+
+	client, _ := newTestClient(t, "Add a.txt")
+	opts := testRunOptions(client)
+	opts.ref = "HEAD"
+	opts.messageFile = messageFile
+	opts.prependTicket = true
+	if err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(messageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ABC-123: Add a.txt\n"; string(b) != want {
+		t.Errorf("message file = %q, want %q", b, want)
+	}
+}
+
+func TestRunPrependTicketCommitEditmsg(t *testing.T) {
+	tests := []struct {
+		name    string
+		editmsg string
+		dryRun  bool
+		want    string
+	}{
+		{name: "commit", editmsg: "ABC-123: \n# Comment\n", want: "ABC-123: Add a.txt"},
+		{name: "dry run", editmsg: "[ABC-123]\n", dryRun: true, want: "[ABC-123] Add a.txt"},
+		// COMMIT_EDITMSG still holds the message of the last commit.
+		{name: "leftover", editmsg: "ABC-9: initial\n", want: "Add a.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := initTestRepo(t)
+			testGit(t, "commit", "--quiet", "--allow-empty", "-m", "ABC-9: initial")
+			writeTestFile(t, "a.txt", "one\n")
+			testGit(t, "add", ".")
+			if err := os.WriteFile(filepath.Join(dir, ".git", "COMMIT_EDITMSG"), []byte(tt.editmsg), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			client, _ := newTestClient(t, "Add a.txt")
+			opts := testRunOptions(client)
+			opts.prependTicket = true
+			opts.dryRun = tt.dryRun
+			var err error
+			out := capture(t, &os.Stdout, func() { err = run(opts) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.dryRun {
+				if !strings.Contains(out, tt.want) {
+					t.Errorf("dry run output doesn't contain %q:\n%s", tt.want, out)
+				}
+				return
+			}
+			if got := testGit(t, "log", "-1", "--format=%s"); got != tt.want {
+				t.Errorf("subject = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var trailerRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*: .+$`)
+
+// splitTrailers separates msg into its body and trailing trailer block. The
+// trailer block is the last paragraph, if every line in it is a trailer.
+func splitTrailers(msg string) (body string, trailers []string) {
+	msg = strings.TrimRight(msg, "\n ")
+	idx := strings.LastIndex(msg, "\n\n")
+	if idx < 0 {
+		// A single paragraph is the subject, never a trailer block.
+		return msg, nil
+	}
+	lines := strings.Split(msg[idx+2:], "\n")
+	for _, line := range lines {
+		if !trailerRe.MatchString(line) {
+			return msg, nil
+		}
+	}
+	return msg[:idx], lines
+}
+
+// appendTrailer adds "key: value" to the trailer block of msg, creating the
+// block if needed. An identical existing trailer is not duplicated.
+func appendTrailer(msg string, key string, value string) string {
+	trailer := key + ": " + value
+	body, trailers := splitTrailers(msg)
+	for _, t := range trailers {
+		if strings.EqualFold(t, trailer) {
+			return body + "\n\n" + strings.Join(trailers, "\n")
+		}
+	}
+	trailers = append(trailers, trailer)
+	return body + "\n\n" + strings.Join(trailers, "\n")
+}
+
+// parseTrailer splits a "Key: Value" trailer, validating git trailer syntax.
+func parseTrailer(s string) (key string, value string, err error) {
+	s = strings.TrimSpace(s)
+	if !trailerRe.MatchString(s) {
+		return "", "", fmt.Errorf("invalid trailer %q: must be \"Key: Value\"", s)
+	}
+	key, value, _ = strings.Cut(s, ": ")
+	return key, strings.TrimSpace(value), nil
+}
+
+func gitConfig(key string) (string, error) {
+	cmd, cancel := gitCommand("config", "--get", key)
+	defer cancel()
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git config %s: %w", key, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// signoffTrailer returns the Signed-off-by value for the configured user.
+func signoffTrailer() (string, error) {
+	name, err := gitConfig("user.name")
+	if err != nil {
+		return "", err
+	}
+	email, err := gitConfig("user.email")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s <%s>", name, email), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitTrailers(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		body     string
+		trailers []string
+	}{
+		{
+			name: "subject only",
+			msg:  "fix: handle empty diffs",
+			body: "fix: handle empty diffs",
+		},
+		{
+			name: "subject that looks like a trailer",
+			msg:  "Fixes: the build",
+			body: "Fixes: the build",
+		},
+		{
+			name:     "trailer block",
+			msg:      "fix: handle empty diffs\n\nSome body.\n\nSigned-off-by: A <a@example.com>\nRefs: #12\n",
+			body:     "fix: handle empty diffs\n\nSome body.",
+			trailers: []string{"Signed-off-by: A <a@example.com>", "Refs: #12"},
+		},
+		{
+			name: "last paragraph is not all trailers",
+			msg:  "fix: handle empty diffs\n\nRefs: #12\nand some prose",
+			body: "fix: handle empty diffs\n\nRefs: #12\nand some prose",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, trailers := splitTrailers(tt.msg)
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if !slices.Equal(trailers, tt.trailers) {
+				t.Errorf("trailers = %q, want %q", trailers, tt.trailers)
+			}
+		})
+	}
+}
+
+func TestAppendTrailer(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   string
+		key   string
+		value string
+		want  string
+	}{
+		{
+			name:  "new block",
+			msg:   "fix: handle empty diffs\n\nSome body.",
+			key:   "Refs",
+			value: "#12",
+			want:  "fix: handle empty diffs\n\nSome body.\n\nRefs: #12",
+		},
+		{
+			name:  "existing block",
+			msg:   "fix: handle empty diffs\n\nRefs: #12",
+			key:   "Signed-off-by",
+			value: "A <a@example.com>",
+			want:  "fix: handle empty diffs\n\nRefs: #12\nSigned-off-by: A <a@example.com>",
+		},
+		{
+			name:  "duplicate ignoring case",
+			msg:   "fix: handle empty diffs\n\nrefs: #12\n",
+			key:   "Refs",
+			value: "#12",
+			want:  "fix: handle empty diffs\n\nrefs: #12",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendTrailer(tt.msg, tt.key, tt.value); got != tt.want {
+				t.Errorf("appendTrailer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrailer(t *testing.T) {
+	tests := []struct {
+		in      string
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{in: "Refs: #12", key: "Refs", value: "#12"},
+		{in: "  Co-authored-by: A <a@example.com>  ", key: "Co-authored-by", value: "A <a@example.com>"},
+		{in: "Refs #12", wantErr: true},
+		{in: "-Refs: #12", wantErr: true},
+		{in: "Refs: ", wantErr: true},
+	}
+	for _, tt := range tests {
+		key, value, err := parseTrailer(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTrailer(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if key != tt.key || value != tt.value {
+			t.Errorf("parseTrailer(%q) = %q, %q, want %q, %q", tt.in, key, value, tt.key, tt.value)
+		}
+	}
+}
+
+func TestSignoffTrailer(t *testing.T) {
+	initTestRepo(t)
+	got, err := signoffTrailer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Test User <test@example.com>"; got != want {
+		t.Errorf("signoffTrailer() = %q, want %q", got, want)
+	}
+}
@@ -41,7 +41,7 @@ require (
 	github.com/go-git/go-git/v5 v5.12.0
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/mattn/go-runewidth v0.0.16 // indirect
+	github.com/mattn/go-runewidth v0.0.16
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/sashabaranov/go-openai v1.29.0
 	github.com/spf13/pflag v1.0.6 // indirect